@@ -0,0 +1,27 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+func add(a, b int) int {
+	return a + b
+}
+
+func scale(x float64, n int8) float64 {
+	return x * float64(n)
+}
+
+func fail() {
+	panic("fail called")
+}
+
+func main() {
+	sum := add(1, 2)
+	fmt.Println(sum)
+	fmt.Println(scale(1.5, 2))
+	if len(os.Args) > 1 {
+		fail()
+	}
+}
@@ -0,0 +1,13 @@
+package main
+
+import "fmt"
+
+func work(i int) {
+	fmt.Println("work", i)
+}
+
+func main() {
+	for i := 0; i < 3; i++ {
+		work(i)
+	}
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+)
+
+func agoroutine(n int, done chan struct{}) {
+	<-done
+	fmt.Println(n)
+}
+
+func stop() {
+	fmt.Println("all goroutines parked")
+}
+
+func main() {
+	done := make(chan struct{})
+	for i := 0; i < 10; i++ {
+		go agoroutine(i, done)
+	}
+	runtime.Gosched()
+	stop()
+	close(done)
+}
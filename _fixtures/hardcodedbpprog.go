@@ -0,0 +1,13 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+)
+
+func main() {
+	a := 1
+	runtime.Breakpoint()
+	a++
+	fmt.Println(a)
+}
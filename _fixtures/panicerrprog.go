@@ -0,0 +1,15 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+func explode(err error) {
+	panic(err)
+}
+
+func main() {
+	fmt.Println("about to panic")
+	explode(errors.New("something went wrong"))
+}
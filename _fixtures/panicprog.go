@@ -0,0 +1,12 @@
+package main
+
+import "fmt"
+
+func explode(msg string) {
+	panic(msg)
+}
+
+func main() {
+	fmt.Println("about to panic")
+	explode("something went wrong")
+}
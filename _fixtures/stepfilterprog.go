@@ -0,0 +1,24 @@
+package main
+
+import "fmt"
+
+type counter struct {
+	n int
+}
+
+func (c counter) Count() int {
+	return c.n
+}
+
+type countable interface {
+	Count() int
+}
+
+func count(c countable) int {
+	return c.Count()
+}
+
+func main() {
+	n := count(&counter{n: 42})
+	fmt.Println(n)
+}
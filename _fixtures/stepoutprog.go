@@ -0,0 +1,19 @@
+package main
+
+import "fmt"
+
+func sum(n int) int {
+	if n == 0 {
+		return 0
+	}
+	return n + sum(n-1)
+}
+
+func divmod(a, b int) (q, r int) {
+	return a / b, a % b
+}
+
+func main() {
+	fmt.Println(sum(3))
+	fmt.Println(divmod(7, 2))
+}
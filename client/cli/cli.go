@@ -8,6 +8,8 @@ import (
 	"os"
 	"os/exec"
 	"os/signal"
+	"sort"
+	"strconv"
 	"strings"
 	"syscall"
 
@@ -18,23 +20,27 @@ import (
 
 const historyFile string = ".dbg_history"
 
-func Run(run bool, pid int, args []string) {
+func Run(run bool, pid int, stopOnPanic bool, args []string) {
 	var (
-		dbp *proctl.DebuggedProcess
-		err error
+		dbp    *proctl.DebuggedProcess
+		err    error
+		launch func(args []string) (*proctl.DebuggedProcess, error)
 	)
 
 	switch {
 	case run:
 		const debugname = "debug"
-		cmd := exec.Command("go", "build", "-o", debugname, "-gcflags", "-N -l")
-		err := cmd.Run()
-		if err != nil {
-			die(1, "Could not compile program:", err)
+		launch = func(args []string) (*proctl.DebuggedProcess, error) {
+			cmd := exec.Command("go", "build", "-o", debugname, "-gcflags", "-N -l")
+			if err := cmd.Run(); err != nil {
+				return nil, fmt.Errorf("could not compile program: %s", err)
+			}
+
+			return proctl.Launch(append([]string{"./" + debugname}, args...))
 		}
 		defer os.Remove(debugname)
 
-		dbp, err = proctl.Launch(append([]string{"./" + debugname}, args...))
+		dbp, err = launch(args)
 		if err != nil {
 			die(1, "Could not launch program:", err)
 		}
@@ -44,12 +50,40 @@ func Run(run bool, pid int, args []string) {
 			die(1, "Could not attach to process:", err)
 		}
 	default:
-		dbp, err = proctl.Launch(args)
+		prog := args[0]
+		launch = func(args []string) (*proctl.DebuggedProcess, error) {
+			return proctl.Launch(append([]string{prog}, args...))
+		}
+
+		dbp, err = launch(args[1:])
 		if err != nil {
 			die(1, "Could not launch program:", err)
 		}
 	}
 
+	if stopOnPanic {
+		if err := dbp.StopOnPanic(true); err != nil {
+			fmt.Fprintf(os.Stderr, "Could not stop on panics: %s\n", err)
+		}
+	}
+
+	runSession(dbp, launch)
+}
+
+// Opens a core file of exe and begins a post-mortem debug session.
+func RunCore(exe, core string) {
+	dbp, err := proctl.OpenCore(exe, core)
+	if err != nil {
+		die(1, "Could not open core file:", err)
+	}
+
+	runSession(dbp, nil)
+}
+
+// Runs the interactive session, launch relaunches the program with
+// new arguments on restart, it is nil when the program can't be
+// restarted.
+func runSession(dbp *proctl.DebuggedProcess, launch func(args []string) (*proctl.DebuggedProcess, error)) {
 	ch := make(chan os.Signal, 1)
 	signal.Notify(ch, syscall.SIGINT)
 	go func() {
@@ -64,41 +98,178 @@ func Run(run bool, pid int, args []string) {
 	goreadline.LoadHistoryFromFile(historyFile)
 	fmt.Println("Type 'help' for list of commands.")
 
-	dbp.Listen(func() {
-		for {
-			if err := command.PrintContext(dbp); err != nil {
-				fmt.Print("Print context faild: ", err.Error())
-			}
+	var (
+		progArgs []string
+		err      error
+	)
+	for {
+		restart, checkpoint := false, 0
 
-			cmdstr, err := promptForInput()
-			if err != nil {
-				if err == io.EOF {
+		dbp.Listen(func() {
+			for {
+				if err := cmds.RunBreakpointCommands(dbp); err != nil && err != proctl.ErrInterrupt {
+					fmt.Fprintf(os.Stderr, "Breakpoint commands failed: %s\n", err)
+				}
+
+				if err := command.PrintContext(dbp); err != nil {
+					fmt.Print("Print context faild: ", err.Error())
+				}
+
+				cmdstr, err := promptForInput()
+				if err != nil {
+					if err == io.EOF {
+						handleExit(dbp, 0)
+					}
+					die(1, "Prompt for input failed.\n")
+				}
+
+				cmdstr, args := parseCommand(cmdstr)
+
+				if cmdstr == "exit" {
 					handleExit(dbp, 0)
 				}
-				die(1, "Prompt for input failed.\n")
+
+				if cmdstr == "restart" {
+					checkpoint, err = checkpointArg(dbp, args)
+					if err != nil {
+						fmt.Fprintf(os.Stderr, "Command failed: %s\n", err)
+						continue
+					}
+					if checkpoint == 0 && launch == nil {
+						fmt.Fprintln(os.Stderr, "Command failed: only launched programs can be restarted")
+						continue
+					}
+
+					if err := dbp.Kill(); err != nil {
+						fmt.Fprintf(os.Stderr, "Command failed: %s\n", err)
+						continue
+					}
+
+					if checkpoint == 0 && len(args) > 0 {
+						progArgs = args
+					}
+					restart = true
+					return
+				}
+
+				cmd := cmds.Find(cmdstr)
+				err = cmd(dbp, args...)
+				if err != nil && err != proctl.ErrInterrupt {
+					fmt.Fprintf(os.Stderr, "Command failed: %s\n", err)
+				}
 			}
+		})
 
-			cmdstr, args := parseCommand(cmdstr)
+		if !restart {
+			return
+		}
 
-			if cmdstr == "exit" {
-				handleExit(dbp, 0)
+		var newdbp *proctl.DebuggedProcess
+		if checkpoint != 0 {
+			newdbp, err = dbp.RestartCheckpoint(checkpoint)
+			if err != nil {
+				die(1, "Could not restart from checkpoint:", err)
 			}
+		} else {
+			dbp.KillCheckpoints()
+			newdbp, err = launch(progArgs)
+			if err != nil {
+				die(1, "Could not launch program:", err)
+			}
+		}
+		restoreBreakpoints(dbp, newdbp)
+		newdbp.RestoreSignalPolicies(dbp)
+		newdbp.RestoreStepFilters(dbp)
+		if dbp.StopsOnPanic() {
+			if err := newdbp.StopOnPanic(true); err != nil {
+				fmt.Fprintf(os.Stderr, "Could not stop on panics: %s\n", err)
+			}
+		}
+		dbp = newdbp
+	}
+}
+
+// Sets the user breakpoints of old on its relaunched process with the
+// same ids, they are looked up again by location since addresses may
+// have moved.
+func restoreBreakpoints(old, dbp *proctl.DebuggedProcess) {
+	bps := make([]*proctl.Breakpoint, 0, len(old.Breakpoints))
+	for _, bp := range old.Breakpoints {
+		if old.BreakpointExists(bp.Addr) {
+			bps = append(bps, bp)
+		}
+	}
+	sort.Sort(byID(bps))
 
-			cmd := cmds.Find(cmdstr)
-			err = cmd(dbp, args...)
-			if err != nil && err != proctl.ErrInterrupt {
-				fmt.Fprintf(os.Stderr, "Command failed: %s\n", err)
+	for _, bp := range bps {
+		loc := bp.Location
+		if loc == "" {
+			loc = fmt.Sprintf("%#v", bp.Addr)
+		}
+
+		nbp, err := dbp.BreakByLocation(loc)
+		if err != nil {
+			fmt.Printf("Breakpoint %d at %s no longer resolves: %s\n", bp.ID, loc, err)
+			continue
+		}
+		dbp.RestoreBreakpointID(old, nbp, bp.ID)
+
+		if bp.Cond != "" {
+			if _, err := dbp.SetCondition(nbp.ID, bp.Cond); err != nil {
+				fmt.Printf("Breakpoint %d: %s\n", nbp.ID, err)
 			}
 		}
-	})
+		nbp.OneShot = bp.OneShot
+		nbp.IgnoreCount = bp.IgnoreCount
+		nbp.Commands = bp.Commands
+
+		fmt.Printf("Breakpoint %d set at %#v for %s %s:%d\n", nbp.ID, nbp.Addr, nbp.FunctionName, nbp.File, nbp.Line)
+	}
+
+	for _, bp := range old.HWBreakpoints {
+		if bp != nil {
+			fmt.Printf("Watchpoint %d on %s not restored\n", bp.ID, bp.Expr)
+		}
+	}
+}
+
+// Returns the id of the checkpoint restart -c goes back to, 0 when args
+// are arguments to relaunch the program with.
+func checkpointArg(dbp *proctl.DebuggedProcess, args []string) (int, error) {
+	if len(args) == 0 || args[0] != "-c" {
+		return 0, nil
+	}
+	if len(args) != 2 {
+		return 0, fmt.Errorf("usage: restart -c $checkpoint")
+	}
+
+	id, err := strconv.Atoi(args[1])
+	if err != nil {
+		return 0, fmt.Errorf("invalid checkpoint id %s", args[1])
+	}
+	if _, err := dbp.FindCheckpoint(id); err != nil {
+		return 0, err
+	}
+
+	return id, nil
 }
 
+type byID []*proctl.Breakpoint
+
+func (s byID) Len() int           { return len(s) }
+func (s byID) Less(i, j int) bool { return s[i].ID < s[j].ID }
+func (s byID) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
 func handleExit(dbp *proctl.DebuggedProcess, status int) {
 	errno := goreadline.WriteHistoryToFile(historyFile)
 	if errno != 0 {
 		fmt.Println("readline:", errno)
 	}
 
+	if dbp.IsCore() {
+		die(status, "Hope I was of service hunting your bug!")
+	}
+
 	prompt := "Would you like to kill the process? [y/n]"
 	answerp := goreadline.ReadLine(&prompt)
 	if answerp == nil {
@@ -111,6 +282,11 @@ func handleExit(dbp *proctl.DebuggedProcess, status int) {
 			fmt.Printf("Can't clear breakpoint @%x: %s\n", pc, err)
 		}
 	}
+	if err := dbp.StopOnPanic(false); err != nil {
+		fmt.Printf("Can't clear panic breakpoints: %s\n", err)
+	}
+
+	dbp.KillCheckpoints()
 
 	fmt.Println("Detaching from process...")
 	dbp.Detach()
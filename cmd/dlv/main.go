@@ -23,16 +23,18 @@ func init() {
 
 func main() {
 	var (
-		pid     int
-		run     bool
-		printv  bool
-		verbose bool
+		pid         int
+		run         bool
+		printv      bool
+		verbose     bool
+		stopOnPanic bool
 	)
 
 	flag.IntVar(&pid, "pid", 0, "Pid of running process to attach to.")
 	flag.BoolVar(&run, "run", false, "Compile program and begin debug session.")
 	flag.BoolVar(&printv, "v", false, "Print version number and exit.")
 	flag.BoolVar(&verbose, "verbose", false, "Print debug log")
+	flag.BoolVar(&stopOnPanic, "stop-on-panic", true, "Stop when a goroutine panics without recovering or the runtime throws a fatal error.")
 	flag.Parse()
 
 	if verbose {
@@ -55,5 +57,13 @@ func main() {
 		os.Exit(0)
 	}
 
-	cli.Run(run, pid, flag.Args())
+	if flag.Arg(0) == "core" {
+		if flag.NArg() != 3 {
+			fmt.Println("Usage: dlv core <executable> <core>")
+			os.Exit(1)
+		}
+		cli.RunCore(flag.Arg(1), flag.Arg(2))
+	}
+
+	cli.Run(run, pid, stopOnPanic, flag.Args())
 }
@@ -9,7 +9,9 @@ import (
 	"os"
 	"regexp"
 	"sort"
+	"strconv"
 	"strings"
+	"syscall"
 
 	"github.com/chendesheng/delve/proctl"
 )
@@ -35,6 +37,11 @@ func (c command) match(cmdstr string) bool {
 type Commands struct {
 	cmds    []command
 	lastCmd cmdfunc
+
+	//breakpoint stop whose commands ran last, so they run once per
+	//stop, the process is part of it as a restart numbers stops anew
+	lastStopProcess *proctl.DebuggedProcess
+	lastStop        int
 }
 
 // Returns a Commands struct with default commands defined.
@@ -43,16 +50,39 @@ func DebugCommands() *Commands {
 
 	c.cmds = []command{
 		command{aliases: []string{"help"}, cmdFn: c.help, helpMsg: "Prints the help message."},
-		command{aliases: []string{"break", "b"}, cmdFn: breakpoint, helpMsg: "Set break point at the entry point of a function, or at a specific file/line. Example: break foo.go:13"},
+		command{aliases: []string{"break", "b"}, cmdFn: breakpoint, helpMsg: "Set break point at the entry point of a function, or at a specific file/line, optionally stopping only when a condition holds. Example: break foo.go:13 if req.ID == 42"},
+		command{aliases: []string{"on"}, cmdFn: c.on, helpMsg: "Run a command when a breakpoint is hit, a final continue resumes the program. Without a command the list is cleared. Example: on 1 print x"},
+		command{aliases: []string{"tbreak"}, cmdFn: tbreakpoint, helpMsg: "Set a breakpoint that is deleted the first time it stops the program. Example: tbreak main.main"},
+		command{aliases: []string{"ignore"}, cmdFn: ignore, helpMsg: "Ignore the next hits of a breakpoint. Example: ignore 1 99"},
+		command{aliases: []string{"condition"}, cmdFn: condition, helpMsg: "Set or remove (no expression) the condition of a breakpoint. Example: condition 1 i > 10"},
 		command{aliases: []string{"breakpoints", "lb"}, cmdFn: breakpoints, helpMsg: "list all breakpoints"},
+		command{aliases: []string{"watch"}, cmdFn: watch, helpMsg: "Stop when a variable is read (-r), written (-w, default) or either (-rw). Example: watch -rw x"},
 		command{aliases: []string{"continue", "c"}, cmdFn: cont, helpMsg: "Run until breakpoint or program termination."},
-		command{aliases: []string{"step", "si"}, cmdFn: step, helpMsg: "Single step through program."},
-		command{aliases: []string{"next", "n"}, cmdFn: next, helpMsg: "Step over to next source line."},
+		command{aliases: []string{"step", "s"}, cmdFn: step, helpMsg: "Step to the next source line, into function calls, n times. Example: step 5"},
+		command{aliases: []string{"stepfilter"}, cmdFn: stepfilter, helpMsg: "List the functions step goes through, add a filter by package prefix, file glob or function regex, or delete one. Example: stepfilter -pkg github.com/lib/pq"},
+		command{aliases: []string{"stepi", "si"}, cmdFn: stepi, helpMsg: "Execute a single machine instruction."},
+		command{aliases: []string{"next", "n"}, cmdFn: next, helpMsg: "Step over to next source line, n times. Example: next 3"},
+		command{aliases: []string{"stepout", "finish"}, cmdFn: stepout, helpMsg: "Run until the current function returns and print its results."},
+		command{aliases: []string{"handle"}, cmdFn: handle, helpMsg: "Print or change what happens when the program receives a signal. Example: handle SIGUSR1 nostop noprint pass"},
+		command{aliases: []string{"signal"}, cmdFn: signal, helpMsg: "Deliver a signal to the program when it resumes, 0 resumes it without the signal it stopped with. Example: signal SIGINT"},
 		command{aliases: []string{"threads"}, cmdFn: threads, helpMsg: "Print out info for every traced thread."},
 		command{aliases: []string{"clear"}, cmdFn: clear, helpMsg: "Deletes breakpoint."},
-		command{aliases: []string{"goroutines"}, cmdFn: goroutines, helpMsg: "Print out info for every goroutine."},
+		command{aliases: []string{"goroutines"}, cmdFn: goroutines, helpMsg: "Print out info for every goroutine, optionally only the ones with a status (-s) or a location matching a regex (-l). Example: goroutines -s waiting -l main.go"},
+		command{aliases: []string{"goroutine"}, cmdFn: c.goroutine, helpMsg: "Select the goroutine print, info and stack refer to, or run a single command in its context. Example: goroutine 12 bt"},
+		command{aliases: []string{"stack", "bt"}, cmdFn: stack, helpMsg: "Print the stack trace of the current goroutine, 50 frames deep by default. Example: bt 10"},
+		command{aliases: []string{"frame"}, cmdFn: frame, helpMsg: "Select the frame print, info locals and info args are evaluated in. Example: frame 2"},
+		command{aliases: []string{"up"}, cmdFn: up, helpMsg: "Select the frame of the caller, or the one n frames up."},
+		command{aliases: []string{"down"}, cmdFn: down, helpMsg: "Select the frame of the callee, or the one n frames down."},
 		command{aliases: []string{"print", "p"}, cmdFn: printVar, helpMsg: "Evaluate a variable."},
+		command{aliases: []string{"examine", "x"}, cmdFn: examine, helpMsg: "Print memory at an address, pointer or &var as hex, dec, oct, bin or char units of 1, 2, 4 or 8 bytes. Example: x -fmt hex -size 4 -count 8 &buf"},
+		command{aliases: []string{"disassemble", "disass"}, cmdFn: disassemble, helpMsg: "Disassemble the current function, the function of a location (-l) or an address range (-a). Example: disassemble -l main.main"},
+		command{aliases: []string{"set"}, cmdFn: setVariable, helpMsg: "Assign a value to a variable, struct field or element in scope. Example: set req.ID = 42"},
+		command{aliases: []string{"call"}, cmdFn: call, helpMsg: "Call a function on the current goroutine and print its results. Example: call add(1, 2)"},
 		command{aliases: []string{"info"}, cmdFn: info, helpMsg: "Provides info about source, locals, args, or funcs."},
+		command{aliases: []string{"dump"}, cmdFn: dump, helpMsg: "Writes a core file of the process. Example: dump core.1234"},
+		command{aliases: []string{"checkpoint"}, cmdFn: checkpoint, helpMsg: "Forks the stopped process, restart -c can go back to it later. Only the current thread is copied, a program with more threads (GOMAXPROCS>1) or goroutines blocked on other threads may deadlock after restarting from it. Example: checkpoint before parsing"},
+		command{aliases: []string{"checkpoints"}, cmdFn: checkpoints, helpMsg: "list all checkpoints"},
+		command{aliases: []string{"restart"}, cmdFn: nullCommand, helpMsg: "Relaunch the program keeping its breakpoints, with new arguments if given, or go back to a checkpoint with -c. Example: restart -v input.txt, restart -c 1"},
 		command{aliases: []string{"exit"}, cmdFn: nullCommand, helpMsg: "Exit the debugger."},
 	}
 
@@ -94,6 +124,140 @@ func (c *Commands) Find(cmdstr string) cmdfunc {
 	return noCmdAvailable
 }
 
+// Returns the command matching cmdstr without touching lastCmd.
+func (c *Commands) lookup(cmdstr string) (command, bool) {
+	for _, v := range c.cmds {
+		if v.match(cmdstr) {
+			return v, true
+		}
+	}
+	return command{}, false
+}
+
+func isContinue(cmdstr string) bool {
+	return cmdstr == "continue" || cmdstr == "c"
+}
+
+// Runs the commands attached to the breakpoint the process just stopped
+// at, once per hit. When they end with continue the process is resumed
+// and the commands of the next breakpoint it stops at run as well.
+func (c *Commands) RunBreakpointCommands(p *proctl.DebuggedProcess) error {
+	for {
+		bp, stop := p.CurrentBreakpointStop()
+		if bp == nil || len(bp.Commands) == 0 {
+			return nil
+		}
+		if p == c.lastStopProcess && stop == c.lastStop {
+			return nil
+		}
+		c.lastStopProcess, c.lastStop = p, stop
+
+		fmt.Printf("Breakpoint %d hit\n", bp.ID)
+
+		resume := false
+		for _, cmdline := range bp.Commands {
+			cmdstr, args := parseCommand(cmdline)
+			if isContinue(cmdstr) {
+				resume = true
+				break
+			}
+
+			cmd, ok := c.lookup(cmdstr)
+			if !ok {
+				return fmt.Errorf("unknown command %s", cmdstr)
+			}
+			if err := cmd.cmdFn(p, args...); err != nil {
+				fmt.Fprintf(os.Stderr, "Command failed: %s\n", err)
+			}
+		}
+
+		if !resume {
+			return nil
+		}
+
+		if err := p.Continue(); err != nil {
+			return err
+		}
+	}
+}
+
+func (c *Commands) on(p *proctl.DebuggedProcess, args ...string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("not enough arguments")
+	}
+
+	id, err := strconv.Atoi(args[0])
+	if err != nil {
+		return fmt.Errorf("invalid breakpoint id %s", args[0])
+	}
+
+	bp, err := p.FindBreakpoint(id)
+	if err != nil {
+		return err
+	}
+
+	if len(args) == 1 {
+		bp.Commands = nil
+		fmt.Printf("Commands of breakpoint %d cleared\n", bp.ID)
+		return nil
+	}
+
+	cmdstr := args[1]
+	if _, ok := c.lookup(cmdstr); !ok {
+		return fmt.Errorf("unknown command %s", cmdstr)
+	}
+	if n := len(bp.Commands); n > 0 {
+		if last, _ := parseCommand(bp.Commands[n-1]); isContinue(last) {
+			return fmt.Errorf("breakpoint %d already continues, continue must be the last command", bp.ID)
+		}
+	}
+
+	bp.Commands = append(bp.Commands, strings.Join(args[1:], " "))
+
+	return nil
+}
+
+func (c *Commands) goroutine(p *proctl.DebuggedProcess, args ...string) error {
+	if len(args) == 0 {
+		fmt.Printf("Goroutine %d\n", p.SelectedGoroutine())
+		return nil
+	}
+
+	gid, err := strconv.Atoi(args[0])
+	if err != nil {
+		return fmt.Errorf("invalid goroutine id %s", args[0])
+	}
+
+	prev := p.SelectedGoroutine()
+	if err := p.SelectGoroutine(gid); err != nil {
+		return err
+	}
+
+	if len(args) == 1 {
+		fmt.Printf("Switched to goroutine %d\n", gid)
+		return nil
+	}
+
+	cmd, ok := c.lookup(args[1])
+	if !ok {
+		return fmt.Errorf("unknown command %s", args[1])
+	}
+
+	err = cmd.cmdFn(p, args[2:]...)
+	if gid == p.SelectedGoroutine() {
+		if serr := p.SelectGoroutine(prev); serr != nil {
+			fmt.Fprintf(os.Stderr, "Could not switch back to goroutine %d: %s\n", prev, serr)
+		}
+	}
+
+	return err
+}
+
+func parseCommand(cmdstr string) (string, []string) {
+	vals := strings.Split(cmdstr, " ")
+	return vals[0], vals[1:]
+}
+
 func CommandFunc(fn func() error) cmdfunc {
 	return func(p *proctl.DebuggedProcess, args ...string) error {
 		return fn()
@@ -120,8 +284,114 @@ func threads(p *proctl.DebuggedProcess, ars ...string) error {
 	return p.PrintThreadInfo()
 }
 
-func goroutines(p *proctl.DebuggedProcess, ars ...string) error {
-	return p.PrintGoroutinesInfo()
+func goroutines(p *proctl.DebuggedProcess, args ...string) error {
+	var (
+		status string
+		filter *regexp.Regexp
+	)
+
+	for len(args) > 0 {
+		if len(args) < 2 {
+			return fmt.Errorf("expected [-s status] [-l regex]")
+		}
+
+		switch args[0] {
+		case "-s":
+			status = args[1]
+		case "-l":
+			var err error
+			if filter, err = regexp.Compile(args[1]); err != nil {
+				return fmt.Errorf("invalid filter argument: %s", err.Error())
+			}
+		default:
+			return fmt.Errorf("unknown flag %s, expected -s or -l", args[0])
+		}
+		args = args[2:]
+	}
+
+	return p.PrintGoroutinesInfo(status, filter)
+}
+
+func stack(p *proctl.DebuggedProcess, args ...string) error {
+	depth := 50
+	if len(args) > 0 {
+		var err error
+		if depth, err = strconv.Atoi(args[0]); err != nil || depth <= 0 {
+			return fmt.Errorf("invalid depth %s", args[0])
+		}
+	}
+
+	frames, err := p.Stacktrace(depth)
+	if err != nil {
+		return err
+	}
+
+	for i := range frames {
+		printFrame(i, &frames[i])
+	}
+	return nil
+}
+
+func printFrame(n int, f *proctl.Stackframe) {
+	args := make([]string, 0, len(f.Arguments))
+	for _, v := range f.Arguments {
+		args = append(args, fmt.Sprintf("%s=%s", v.Name, v.Value))
+	}
+
+	fmt.Printf("#%d %#v in %s(%s) at %s:%d\n", n, f.PC, f.Function, strings.Join(args, ", "), f.File, f.Line)
+}
+
+func frame(p *proctl.DebuggedProcess, args ...string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("not enough arguments")
+	}
+
+	n, err := strconv.Atoi(args[0])
+	if err != nil {
+		return fmt.Errorf("invalid frame %s", args[0])
+	}
+
+	return selectFrame(p, n)
+}
+
+func up(p *proctl.DebuggedProcess, args ...string) error {
+	n, err := frameCount(args)
+	if err != nil {
+		return err
+	}
+
+	return selectFrame(p, p.SelectedFrame()+n)
+}
+
+func down(p *proctl.DebuggedProcess, args ...string) error {
+	n, err := frameCount(args)
+	if err != nil {
+		return err
+	}
+
+	return selectFrame(p, p.SelectedFrame()-n)
+}
+
+func frameCount(args []string) (int, error) {
+	if len(args) == 0 {
+		return 1, nil
+	}
+
+	n, err := strconv.Atoi(args[0])
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid frame count %s", args[0])
+	}
+	return n, nil
+}
+
+func selectFrame(p *proctl.DebuggedProcess, n int) error {
+	f, err := p.SelectFrame(n)
+	if err != nil {
+		return err
+	}
+
+	printFrame(n, f)
+	return nil
 }
 
 func cont(p *proctl.DebuggedProcess, ars ...string) error {
@@ -134,7 +404,11 @@ func cont(p *proctl.DebuggedProcess, ars ...string) error {
 }
 
 func step(p *proctl.DebuggedProcess, args ...string) error {
-	err := p.Step()
+	return repeatStep(p, p.Step, args...)
+}
+
+func stepi(p *proctl.DebuggedProcess, args ...string) error {
+	err := p.StepInstruction()
 	if err != nil {
 		return err
 	}
@@ -143,14 +417,200 @@ func step(p *proctl.DebuggedProcess, args ...string) error {
 }
 
 func next(p *proctl.DebuggedProcess, args ...string) error {
-	err := p.Next()
+	return repeatStep(p, p.Next, args...)
+}
+
+// Steps count times, as given by the only argument, 1 by default.
+// Stops early when a step ends at a breakpoint.
+func repeatStep(p *proctl.DebuggedProcess, stepFn func() error, args ...string) error {
+	count := 1
+	if len(args) > 0 {
+		n, err := strconv.Atoi(args[0])
+		if err != nil || n <= 0 {
+			return fmt.Errorf("invalid count %s", args[0])
+		}
+		count = n
+	}
+
+	for i := 0; i < count; i++ {
+		if err := stepFn(); err != nil {
+			return err
+		}
+		if p.CurrentBreakpoint() != nil {
+			break
+		}
+	}
+
+	return printcontext(p)
+}
+
+func stepout(p *proctl.DebuggedProcess, args ...string) error {
+	frames, err := p.Stacktrace(p.SelectedFrame() + 1)
+	if err != nil {
+		return err
+	}
+	if n := len(frames) - 1; n == p.SelectedFrame() {
+		fmt.Print("Run till exit from ")
+		printFrame(n, &frames[n])
+	}
+
+	results, err := p.StepOut()
 	if err != nil {
 		return err
 	}
 
+	for _, v := range results {
+		fmt.Printf("Value returned: %s = %s\n", v.Name, v.Value)
+	}
+
 	return printcontext(p)
 }
 
+func examine(p *proctl.DebuggedProcess, args ...string) error {
+	var (
+		format = proctl.HexFormat
+		size   = 0
+		count  = 0
+		err    error
+	)
+
+	for len(args) > 1 && strings.HasPrefix(args[0], "-") {
+		switch args[0] {
+		case "-fmt":
+			format, err = proctl.ParseMemoryFormat(args[1])
+		case "-size":
+			size, err = strconv.Atoi(args[1])
+		case "-count":
+			count, err = strconv.Atoi(args[1])
+		default:
+			return fmt.Errorf("unknown flag %s, expected -fmt, -size or -count", args[0])
+		}
+		if err != nil {
+			return err
+		}
+		args = args[2:]
+	}
+
+	if len(args) == 0 {
+		return fmt.Errorf("not enough arguments")
+	}
+
+	if size == 0 {
+		size = 1
+	}
+	if format == proctl.CharFormat && size != 1 {
+		return fmt.Errorf("the char format prints single bytes, -size must be 1")
+	}
+	if count == 0 {
+		//one row of the dump
+		count = 16 / size
+	}
+
+	addr, data, err := p.ExamineMemory(strings.Join(args, " "), size, count)
+	if err != nil {
+		return err
+	}
+
+	fmt.Print(proctl.FormatMemory(addr, data, format, size))
+	return nil
+}
+
+func disassemble(p *proctl.DebuggedProcess, args ...string) error {
+	var (
+		start, end uint64
+		err        error
+	)
+
+	switch {
+	case len(args) == 0:
+		pc, err := p.CurrentPCForDisplay()
+		if err != nil {
+			return err
+		}
+		if start, end, err = functionRange(p, pc); err != nil {
+			return err
+		}
+	case args[0] == "-a" && len(args) == 3:
+		if start, err = strconv.ParseUint(args[1], 0, 64); err != nil {
+			return fmt.Errorf("invalid start address %s", args[1])
+		}
+		if end, err = strconv.ParseUint(args[2], 0, 64); err != nil {
+			return fmt.Errorf("invalid end address %s", args[2])
+		}
+	case args[0] == "-l" && len(args) == 2:
+		pc, err := p.FindLocation(args[1])
+		if err != nil {
+			return err
+		}
+		if start, end, err = functionRange(p, pc); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("usage: disassemble [-a <start> <end> | -l <location>]")
+	}
+
+	insts, err := p.Disassemble(start, end)
+	if err != nil {
+		return err
+	}
+
+	var fn string
+	for _, inst := range insts {
+		if inst.Function != fn {
+			fn = inst.Function
+			if fn != "" {
+				fmt.Printf("%s:\n", fn)
+			}
+		}
+
+		mark := "   "
+		switch {
+		case inst.AtPC && inst.Breakpoint:
+			mark = "*=>"
+		case inst.AtPC:
+			mark = " =>"
+		case inst.Breakpoint:
+			mark = "*  "
+		}
+		fmt.Printf("%s %#x\t%s:%d\t%-20x\t%s\n", mark, inst.PC, inst.File, inst.Line, inst.Bytes, inst.Text)
+	}
+	return nil
+}
+
+// Returns the bounds of the function pc is in.
+func functionRange(p *proctl.DebuggedProcess, pc uint64) (uint64, uint64, error) {
+	fn := p.GoSymTable.PCToFunc(pc)
+	if fn == nil {
+		return 0, 0, fmt.Errorf("no function at %#x, use -a to disassemble an address range", pc)
+	}
+	return fn.Entry, fn.End, nil
+}
+
+func setVariable(p *proctl.DebuggedProcess, args ...string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("not enough arguments")
+	}
+
+	return p.SetVariable(strings.Join(args, " "))
+}
+
+func call(p *proctl.DebuggedProcess, args ...string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("not enough arguments")
+	}
+
+	results, err := p.Call(strings.Join(args, " "))
+	if err != nil {
+		return err
+	}
+
+	for _, v := range results {
+		fmt.Printf("%s = %s\n", v.Name, v.Value)
+	}
+
+	return nil
+}
+
 func clear(p *proctl.DebuggedProcess, args ...string) error {
 	if len(args) == 0 {
 		return fmt.Errorf("not enough arguments")
@@ -161,26 +621,198 @@ func clear(p *proctl.DebuggedProcess, args ...string) error {
 		return err
 	}
 
+	if bp.Watch != 0 {
+		fmt.Printf("Watchpoint %d cleared at %#v for %s\n", bp.ID, bp.Addr, bp.Expr)
+		return nil
+	}
+
 	fmt.Printf("Breakpoint %d cleared at %#v for %s %s:%d\n", bp.ID, bp.Addr, bp.FunctionName, bp.File, bp.Line)
 
 	return nil
 }
 
 func breakpoint(p *proctl.DebuggedProcess, args ...string) error {
+	return setBreakpoint(p, false, args...)
+}
+
+func tbreakpoint(p *proctl.DebuggedProcess, args ...string) error {
+	return setBreakpoint(p, true, args...)
+}
+
+func setBreakpoint(p *proctl.DebuggedProcess, oneShot bool, args ...string) error {
 	if len(args) == 0 {
 		return fmt.Errorf("not enough arguments")
 	}
 
+	var cond string
+	if len(args) > 1 {
+		if args[1] != "if" || len(args) == 2 {
+			return fmt.Errorf("expected <location> [if <condition>]")
+		}
+		cond = strings.Join(args[2:], " ")
+	}
+
 	bp, err := p.BreakByLocation(args[0])
 	if err != nil {
 		return err
 	}
 
+	if cond != "" {
+		if _, err := p.SetCondition(bp.ID, cond); err != nil {
+			p.Clear(bp.Addr)
+			return err
+		}
+	}
+	bp.OneShot = oneShot
+
 	fmt.Printf("Breakpoint %d set at %#v for %s %s:%d\n", bp.ID, bp.Addr, bp.FunctionName, bp.File, bp.Line)
 
 	return nil
 }
 
+func ignore(p *proctl.DebuggedProcess, args ...string) error {
+	if len(args) < 2 {
+		return fmt.Errorf("not enough arguments")
+	}
+
+	id, err := strconv.Atoi(args[0])
+	if err != nil {
+		return fmt.Errorf("invalid breakpoint id %s", args[0])
+	}
+
+	count, err := strconv.Atoi(args[1])
+	if err != nil {
+		return fmt.Errorf("invalid ignore count %s", args[1])
+	}
+
+	bp, err := p.SetIgnoreCount(id, count)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Will ignore next %d crossings of breakpoint %d\n", bp.IgnoreCount, bp.ID)
+
+	return nil
+}
+
+func condition(p *proctl.DebuggedProcess, args ...string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("not enough arguments")
+	}
+
+	id, err := strconv.Atoi(args[0])
+	if err != nil {
+		return fmt.Errorf("invalid breakpoint id %s", args[0])
+	}
+
+	bp, err := p.SetCondition(id, strings.Join(args[1:], " "))
+	if err != nil {
+		return err
+	}
+
+	if bp.Cond == "" {
+		fmt.Printf("Breakpoint %d is now unconditional\n", bp.ID)
+	} else {
+		fmt.Printf("Breakpoint %d condition set to %s\n", bp.ID, bp.Cond)
+	}
+
+	return nil
+}
+
+func watch(p *proctl.DebuggedProcess, args ...string) error {
+	wt := proctl.WatchWrite
+	if len(args) > 0 {
+		switch args[0] {
+		case "-r":
+			wt, args = proctl.WatchRead, args[1:]
+		case "-w":
+			wt, args = proctl.WatchWrite, args[1:]
+		case "-rw":
+			wt, args = proctl.WatchReadWrite, args[1:]
+		}
+	}
+
+	if len(args) == 0 {
+		return fmt.Errorf("not enough arguments")
+	}
+
+	bp, err := p.Watch(args[0], wt)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Watchpoint %d set at %#v for %s\n", bp.ID, bp.Addr, bp.Expr)
+
+	return nil
+}
+
+func handle(p *proctl.DebuggedProcess, args ...string) error {
+	if len(args) == 0 {
+		p.PrintSignalInfo()
+		return nil
+	}
+
+	sig, err := proctl.ParseSignal(args[0])
+	if err != nil {
+		return err
+	}
+
+	//like gdb, stopping implies printing
+	policy := p.SignalPolicy(sig)
+	for _, action := range args[1:] {
+		switch action {
+		case "stop":
+			policy.Stop, policy.Print = true, true
+		case "nostop":
+			policy.Stop = false
+		case "print":
+			policy.Print = true
+		case "noprint":
+			policy.Print, policy.Stop = false, false
+		case "pass":
+			policy.Pass = true
+		case "nopass":
+			policy.Pass = false
+		default:
+			return fmt.Errorf("expected stop, nostop, print, noprint, pass or nopass, got %s", action)
+		}
+	}
+
+	if len(args) > 1 {
+		if err := p.HandleSignal(sig, policy); err != nil {
+			return err
+		}
+	}
+
+	p.PrintSignalInfo(sig)
+	return nil
+}
+
+func signal(p *proctl.DebuggedProcess, args ...string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("not enough arguments")
+	}
+
+	var sig syscall.Signal
+	if args[0] != "0" {
+		var err error
+		if sig, err = proctl.ParseSignal(args[0]); err != nil {
+			return err
+		}
+	}
+
+	if err := p.Signal(sig); err != nil {
+		return err
+	}
+
+	if sig == 0 {
+		fmt.Println("The program will resume without a signal")
+	} else {
+		fmt.Printf("%s will be delivered when the program resumes\n", proctl.SignalName(sig))
+	}
+	return nil
+}
+
 func breakpoints(p *proctl.DebuggedProcess, args ...string) error {
 	p.PrintBreakpoints()
 	return nil
@@ -200,6 +832,77 @@ func printVar(p *proctl.DebuggedProcess, args ...string) error {
 	return nil
 }
 
+func dump(p *proctl.DebuggedProcess, args ...string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("not enough arguments")
+	}
+
+	if err := p.Dump(args[0]); err != nil {
+		return err
+	}
+
+	fmt.Printf("Core file written to %s\n", args[0])
+	return nil
+}
+
+func checkpoint(p *proctl.DebuggedProcess, args ...string) error {
+	cp, err := p.Checkpoint(strings.Join(args, " "))
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Checkpoint %d at %#v for %s %s:%d\n", cp.ID, cp.PC, cp.Function, cp.File, cp.Line)
+	if cp.LostThreads > 0 {
+		fmt.Printf("Warning: only the current thread is copied, %d other threads are not. Goroutines running or blocked on them are lost and the checkpoint may deadlock after restart -c, run the program with GOMAXPROCS=1 to avoid it\n", cp.LostThreads)
+	}
+	return nil
+}
+
+func checkpoints(p *proctl.DebuggedProcess, args ...string) error {
+	p.PrintCheckpoints()
+	return nil
+}
+
+func stepfilter(p *proctl.DebuggedProcess, args ...string) error {
+	if len(args) == 0 {
+		for i, f := range p.StepFilters() {
+			fmt.Printf("%d\t%s\t%s\n", i+1, f.Kind, f.Pattern)
+		}
+		return nil
+	}
+
+	if len(args) != 2 {
+		return fmt.Errorf("usage: stepfilter [-pkg <prefix> | -file <glob> | -func <regex> | -d <n>]")
+	}
+
+	var kind proctl.StepFilterKind
+	switch args[0] {
+	case "-pkg":
+		kind = proctl.PackageFilter
+	case "-file":
+		kind = proctl.FileFilter
+	case "-func":
+		kind = proctl.FunctionFilter
+	case "-d":
+		n, err := strconv.Atoi(args[1])
+		if err != nil {
+			return fmt.Errorf("invalid step filter %s", args[1])
+		}
+		return p.RemoveStepFilter(n)
+	default:
+		return fmt.Errorf("unknown flag %s, expected -pkg, -file, -func or -d", args[0])
+	}
+
+	f, err := proctl.NewStepFilter(kind, args[1])
+	if err != nil {
+		return err
+	}
+	p.AddStepFilter(f)
+
+	fmt.Printf("Step filter %d: %s %s\n", len(p.StepFilters()), f.Kind, f.Pattern)
+	return nil
+}
+
 func filterVariables(vars []*proctl.Variable, filter *regexp.Regexp) []string {
 	data := make([]string, 0, len(vars))
 	for _, v := range vars {
@@ -0,0 +1,110 @@
+package proctl
+
+import (
+	"log"
+	"syscall"
+)
+
+// Backend is the platform specific half of a DebuggedProcess. It gives
+// access to the memory, registers and threads of the traced process,
+// controls its execution and reports the traps that stop it.
+type Backend interface {
+	// Memory
+	ReadMemory(addr uintptr, size int) ([]byte, error)
+	WriteMemory(addr uintptr, data []byte) (int, error)
+
+	// Registers of thread tid. Setters on the returned value write
+	// straight back to the thread.
+	Registers(tid int) (Registers, error)
+	SetRegisters(tid int, r Registers) error
+
+	// Floating point and vector registers of thread tid in the FXSAVE
+	// layout.
+	FpRegisters(tid int) ([]byte, error)
+	SetFpRegisters(tid int, data []byte) error
+
+	// Ids of all traced threads.
+	Threads() ([]int, error)
+
+	// Debug registers DR0-DR7 of thread tid.
+	DebugRegister(tid, reg int) (uint64, error)
+	SetDebugRegister(tid, reg int, value uint64) error
+
+	// Run-control. Suspend and Resume act on the whole process, Halt
+	// asynchronously stops a running process and reports a TE_MANUAL
+	// event.
+	Suspend() error
+	Resume() error
+	Halt() error
+	Detach() error
+
+	// Signal delivered to thread tid on the next Resume, replacing the
+	// one it stopped with. 0 resumes it without a signal.
+	SetSignal(tid int, sig syscall.Signal) error
+
+	// Traps that stop the process, TE_EXIT is always the last one.
+	Events() <-chan *trapEvent
+}
+
+// Returns a DebuggedProcess with sensible defaults, the caller
+// is responsible for setting its backend.
+func newDebuggedProcess(pid int) *DebuggedProcess {
+	return &DebuggedProcess{
+		Pid:         pid,
+		Breakpoints: make(map[uint64]*Breakpoint),
+		goroutines:  make(map[int]*Goroutine),
+		hwThreads:   make(map[int]bool),
+		signals:     make(map[syscall.Signal]SignalPolicy),
+		stepFilters: append([]StepFilter(nil), defaultStepFilters...),
+	}
+}
+
+func (dbp *DebuggedProcess) readMemory(addr uintptr, size int) ([]byte, error) {
+	return dbp.backend.ReadMemory(addr, size)
+}
+
+func (dbp *DebuggedProcess) writeMemory(addr uintptr, data []byte) (int, error) {
+	log.Printf("write memory:%#v, %#v", uint64(addr), data)
+	return dbp.backend.WriteMemory(addr, data)
+}
+
+func (dbp *DebuggedProcess) registers(tid int) (Registers, error) {
+	return dbp.backend.Registers(tid)
+}
+
+func (dbp *DebuggedProcess) setRegisters(tid int, r Registers) error {
+	return dbp.backend.SetRegisters(tid, r)
+}
+
+func (dbp *DebuggedProcess) fpRegisters(tid int) ([]byte, error) {
+	return dbp.backend.FpRegisters(tid)
+}
+
+func (dbp *DebuggedProcess) setFpRegisters(tid int, data []byte) error {
+	return dbp.backend.SetFpRegisters(tid, data)
+}
+
+func (dbp *DebuggedProcess) getThreads() ([]int, error) {
+	return dbp.backend.Threads()
+}
+
+func (dbp *DebuggedProcess) suspend() error {
+	log.Print("suspend()")
+	return dbp.backend.Suspend()
+}
+
+func (dbp *DebuggedProcess) resume() error {
+	log.Print("resume()")
+
+	ths, err := dbp.getThreads()
+	if err != nil {
+		return err
+	}
+	for _, tid := range ths {
+		if err := dbp.syncDebugRegisters(tid); err != nil {
+			return err
+		}
+	}
+
+	return dbp.backend.Resume()
+}
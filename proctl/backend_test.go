@@ -0,0 +1,576 @@
+package proctl
+
+import (
+	"bytes"
+	"debug/dwarf"
+	"debug/gosym"
+	"encoding/binary"
+	"go/parser"
+	"math"
+	"syscall"
+	"testing"
+
+	"github.com/chendesheng/delve/dwarf/frame"
+)
+
+// In-memory backend, lets proctl logic run without a traced process.
+type fakeBackend struct {
+	mem    map[uintptr]byte
+	regs   map[int]*fakeRegs
+	dr     map[int]*[8]uint64
+	sig    map[int]syscall.Signal
+	chTrap chan *trapEvent
+}
+
+type fakeRegs struct {
+	pc, sp, rflags uint64
+}
+
+func (r *fakeRegs) PC() uint64 {
+	return r.pc
+}
+
+func (r *fakeRegs) SP() uint64 {
+	return r.sp
+}
+
+func (r *fakeRegs) SetPC(tid int, pc uint64) error {
+	r.pc = pc
+	return nil
+}
+
+func (r *fakeRegs) Rflags() uint64 {
+	return r.rflags
+}
+
+func (r *fakeRegs) SetRflags(tid int, rflags uint64) error {
+	r.rflags = rflags
+	return nil
+}
+
+func newFakeBackend() *fakeBackend {
+	return &fakeBackend{
+		mem:    make(map[uintptr]byte),
+		regs:   map[int]*fakeRegs{1: &fakeRegs{}},
+		dr:     map[int]*[8]uint64{1: &[8]uint64{}},
+		sig:    make(map[int]syscall.Signal),
+		chTrap: make(chan *trapEvent, 1),
+	}
+}
+
+func (fb *fakeBackend) ReadMemory(addr uintptr, size int) ([]byte, error) {
+	data := make([]byte, size)
+	for i := range data {
+		data[i] = fb.mem[addr+uintptr(i)]
+	}
+	return data, nil
+}
+
+func (fb *fakeBackend) WriteMemory(addr uintptr, data []byte) (int, error) {
+	for i, b := range data {
+		fb.mem[addr+uintptr(i)] = b
+	}
+	return len(data), nil
+}
+
+func (fb *fakeBackend) Registers(tid int) (Registers, error) {
+	return fb.regs[tid], nil
+}
+
+func (fb *fakeBackend) SetRegisters(tid int, r Registers) error {
+	*fb.regs[tid] = *r.(*fakeRegs)
+	return nil
+}
+
+func (fb *fakeBackend) FpRegisters(tid int) ([]byte, error) {
+	return make([]byte, 512), nil
+}
+
+func (fb *fakeBackend) SetFpRegisters(tid int, data []byte) error {
+	return nil
+}
+
+func (fb *fakeBackend) Threads() ([]int, error) {
+	ths := make([]int, 0, len(fb.regs))
+	for tid := range fb.regs {
+		ths = append(ths, tid)
+	}
+	return ths, nil
+}
+
+func (fb *fakeBackend) DebugRegister(tid, reg int) (uint64, error) {
+	return fb.dr[tid][reg], nil
+}
+
+func (fb *fakeBackend) SetDebugRegister(tid, reg int, value uint64) error {
+	fb.dr[tid][reg] = value
+	return nil
+}
+
+func (fb *fakeBackend) Suspend() error {
+	return nil
+}
+
+func (fb *fakeBackend) Resume() error {
+	return nil
+}
+
+func (fb *fakeBackend) Halt() error {
+	return nil
+}
+
+func (fb *fakeBackend) SetSignal(tid int, sig syscall.Signal) error {
+	fb.sig[tid] = sig
+	return nil
+}
+
+func (fb *fakeBackend) Detach() error {
+	return nil
+}
+
+func (fb *fakeBackend) Events() <-chan *trapEvent {
+	return fb.chTrap
+}
+
+const (
+	fakeFuncEntry = 0x400000
+	fakeFuncEnd   = 0x400100
+)
+
+// Runs fn against a process backed by a fakeBackend whose symbol
+// table holds a single function main.fake.
+func withFakeProcess(t *testing.T, fn func(p *DebuggedProcess, fb *fakeBackend)) {
+	p := newDebuggedProcess(1)
+	fb := newFakeBackend()
+	p.backend = fb
+	p.GoSymTable = &gosym.Table{
+		Funcs: []gosym.Func{{
+			Entry:     fakeFuncEntry,
+			End:       fakeFuncEnd,
+			Sym:       &gosym.Sym{Name: "main.fake"},
+			LineTable: gosym.NewLineTable(nil, fakeFuncEntry),
+			Obj:       &gosym.Obj{},
+		}},
+	}
+
+	fn(p, fb)
+}
+
+func TestFakeSetClearBreakpoint(t *testing.T) {
+	withFakeProcess(t, func(p *DebuggedProcess, fb *fakeBackend) {
+		addr := uint64(fakeFuncEntry)
+		fb.mem[uintptr(addr)] = 0x55
+
+		bp, err := p.Break(addr)
+		assertNoError(err, t, "Break()")
+
+		if fb.mem[uintptr(addr)] != 0xcc {
+			t.Fatalf("Expected int3 at %#v got %#v", addr, fb.mem[uintptr(addr)])
+		}
+
+		if !bytes.Equal(bp.OriginalData, []byte{0x55}) {
+			t.Fatalf("Original data not saved: %#v", bp.OriginalData)
+		}
+
+		// A goroutine scoped breakpoint at the same address keeps the int3
+		// around until both are cleared.
+		_, err = p.setBreakpoint(addr, 1)
+		assertNoError(err, t, "setBreakpoint()")
+
+		_, err = p.Clear(addr)
+		assertNoError(err, t, "Clear()")
+
+		if fb.mem[uintptr(addr)] != 0xcc || len(p.Breakpoints) != 1 {
+			t.Fatal("Breakpoint removed while goroutine 1 still uses it")
+		}
+
+		_, err = p.clearBreakpoint(addr, 1)
+		assertNoError(err, t, "clearBreakpoint()")
+
+		if fb.mem[uintptr(addr)] != 0x55 {
+			t.Fatalf("Breakpoint was not cleared data: %#v", fb.mem[uintptr(addr)])
+		}
+
+		if len(p.Breakpoints) != 0 {
+			t.Fatal("Breakpoint not removed internally")
+		}
+	})
+}
+
+func TestFakeExtractValue(t *testing.T) {
+	withFakeProcess(t, func(p *DebuggedProcess, fb *fakeBackend) {
+		g := p.addGoroutine(1, 1)
+
+		intaddr, floataddr, ptraddr := uintptr(0x1000), uintptr(0x2000), uintptr(0x3000)
+
+		buf := make([]byte, 8)
+		binary.LittleEndian.PutUint64(buf, uint64(42))
+		fb.WriteMemory(intaddr, buf)
+		binary.LittleEndian.PutUint64(buf, math.Float64bits(7.23))
+		fb.WriteMemory(floataddr, buf)
+
+		inttyp := &dwarf.IntType{BasicType: dwarf.BasicType{CommonType: dwarf.CommonType{ByteSize: 8, Name: "int"}}}
+		floattyp := &dwarf.FloatType{BasicType: dwarf.BasicType{CommonType: dwarf.CommonType{ByteSize: 8, Name: "float64"}}}
+		ptrtyp := &dwarf.PtrType{CommonType: dwarf.CommonType{ByteSize: 8, Name: "*int"}, Type: inttyp}
+
+		testcases := []struct {
+			addr  uintptr
+			typ   dwarf.Type
+			value string
+		}{
+			{intaddr, inttyp, "42"},
+			{floataddr, floattyp, "7.23"},
+			{ptraddr, ptrtyp, "*int nil"},
+		}
+
+		for _, tc := range testcases {
+			val, err := g.extractValue(nil, int64(tc.addr), tc.typ)
+			assertNoError(err, t, "extractValue()")
+
+			if val != tc.value {
+				t.Fatalf("Expected %s got %s", tc.value, val)
+			}
+		}
+
+		binary.LittleEndian.PutUint64(buf, uint64(intaddr))
+		fb.WriteMemory(ptraddr, buf)
+
+		val, err := g.extractValue(nil, int64(ptraddr), ptrtyp)
+		assertNoError(err, t, "extractValue()")
+
+		if val != "*42" {
+			t.Fatalf("Expected *42 got %s", val)
+		}
+	})
+}
+
+func TestFakeAssign(t *testing.T) {
+	withFakeProcess(t, func(p *DebuggedProcess, fb *fakeBackend) {
+		g := p.addGoroutine(1, 1)
+
+		int8typ := &dwarf.IntType{BasicType: dwarf.BasicType{CommonType: dwarf.CommonType{ByteSize: 1, Name: "int8"}}}
+		uint16typ := &dwarf.UintType{BasicType: dwarf.BasicType{CommonType: dwarf.CommonType{ByteSize: 2, Name: "uint16"}}}
+		float32typ := &dwarf.FloatType{BasicType: dwarf.BasicType{CommonType: dwarf.CommonType{ByteSize: 4, Name: "float32"}}}
+		booltyp := &dwarf.BoolType{BasicType: dwarf.BasicType{CommonType: dwarf.CommonType{ByteSize: 1, Name: "bool"}}}
+		int64typ := &dwarf.IntType{BasicType: dwarf.BasicType{CommonType: dwarf.CommonType{ByteSize: 8, Name: "int64"}}}
+		ptrtyp := &dwarf.PtrType{CommonType: dwarf.CommonType{ByteSize: 8, Name: "*int64"}, Type: int64typ}
+		structtyp := &dwarf.StructType{
+			CommonType: dwarf.CommonType{ByteSize: 24, Name: "main.T"},
+			StructName: "main.T",
+			Field: []*dwarf.StructField{
+				{Name: "a", Type: int8typ, ByteOffset: 0},
+				{Name: "b", Type: uint16typ, ByteOffset: 2},
+				{Name: "f", Type: float32typ, ByteOffset: 4},
+				{Name: "ok", Type: booltyp, ByteOffset: 8},
+				{Name: "p", Type: ptrtyp, ByteOffset: 16},
+			},
+		}
+		arraytyp := &dwarf.ArrayType{CommonType: dwarf.CommonType{ByteSize: 24, Name: "[3]int64"}, Type: int64typ, Count: 3}
+		slicetyp := &dwarf.StructType{
+			CommonType: dwarf.CommonType{ByteSize: 24, Name: "[]int64"},
+			StructName: "[]int64",
+			Field: []*dwarf.StructField{
+				{Name: "array", Type: ptrtyp, ByteOffset: 0},
+				{Name: "len", Type: int64typ, ByteOffset: 8},
+				{Name: "cap", Type: int64typ, ByteOffset: 16},
+			},
+		}
+
+		structaddr, arrayaddr, sliceaddr, backing := uint64(0x1000), uint64(0x2000), uint64(0x3000), uint64(0x4000)
+		fb.WriteMemory(uintptr(structaddr+16), []byte{0x08, 0x40})
+		buf := make([]byte, 24)
+		binary.LittleEndian.PutUint64(buf, backing)
+		binary.LittleEndian.PutUint64(buf[8:], 2)
+		binary.LittleEndian.PutUint64(buf[16:], 2)
+		fb.WriteMemory(uintptr(sliceaddr), buf)
+
+		assign := func(addr uint64, typ dwarf.Type, rhs string) error {
+			e, err := parser.ParseExpr(rhs)
+			assertNoError(err, t, "ParseExpr()")
+			return g.assign(addr, typ, false, e)
+		}
+		field := func(name string) (uint64, dwarf.Type) {
+			addr, typ, _, err := g.fieldAddress(structaddr, structtyp, false, name)
+			assertNoError(err, t, "fieldAddress()")
+			return addr, typ
+		}
+
+		for _, tc := range []struct {
+			field, rhs string
+			bytes      []byte
+		}{
+			{"a", "-3", []byte{0xfd}},
+			{"b", "0x1234", []byte{0x34, 0x12}},
+			{"f", "1.5", []byte{0x00, 0x00, 0xc0, 0x3f}},
+			{"ok", "true", []byte{0x01}},
+			{"p", "nil", make([]byte, 8)},
+		} {
+			addr, typ := field(tc.field)
+			assertNoError(assign(addr, typ, tc.rhs), t, "assign()")
+			if mem, _ := fb.ReadMemory(uintptr(addr), len(tc.bytes)); !bytes.Equal(mem, tc.bytes) {
+				t.Fatalf("%s = %s: expected %#v got %#v", tc.field, tc.rhs, tc.bytes, mem)
+			}
+		}
+
+		//mismatches are rejected before anything is written
+		for _, tc := range []struct{ field, rhs string }{
+			{"a", "128"},
+			{"b", "-1"},
+			{"ok", "1"},
+			{"f", "false"},
+			{"a", `"x"`},
+		} {
+			addr, typ := field(tc.field)
+			before, _ := fb.ReadMemory(uintptr(addr), int(typ.Size()))
+			if err := assign(addr, typ, tc.rhs); err == nil {
+				t.Fatalf("%s = %s: expected an error", tc.field, tc.rhs)
+			}
+			if after, _ := fb.ReadMemory(uintptr(addr), int(typ.Size())); !bytes.Equal(before, after) {
+				t.Fatalf("%s = %s: memory changed to %#v", tc.field, tc.rhs, after)
+			}
+		}
+
+		addr, typ, _, err := g.elementAddress(arrayaddr, arraytyp, false, 2)
+		assertNoError(err, t, "elementAddress()")
+		assertNoError(assign(addr, typ, "7"), t, "assign()")
+		if n, _ := p.readUint64(arrayaddr + 16); n != 7 {
+			t.Fatalf("Expected a[2] to be 7, got %d", n)
+		}
+		if _, _, _, err := g.elementAddress(arrayaddr, arraytyp, false, 3); err == nil {
+			t.Fatal("Expected an index out of range error for a[3]")
+		}
+
+		addr, typ, _, err = g.elementAddress(sliceaddr, slicetyp, false, 1)
+		assertNoError(err, t, "elementAddress()")
+		assertNoError(assign(addr, typ, "9"), t, "assign()")
+		if n, _ := p.readUint64(backing + 8); n != 9 {
+			t.Fatalf("Expected s[1] to be 9, got %d", n)
+		}
+		if _, _, _, err := g.elementAddress(sliceaddr, slicetyp, false, 2); err == nil {
+			t.Fatal("Expected an index out of range error for s[2]")
+		}
+
+		for expr, i := range map[string]int{"x = 1": 2, "a[i] = b == c": 5, "x == 1": -1, `s = "a=b"`: 2, "x <= 1": -1} {
+			if n := assignmentIndex(expr); n != i {
+				t.Fatalf("%s: expected the assignment at %d, got %d", expr, i, n)
+			}
+		}
+	})
+}
+
+func TestFakeWatchpoint(t *testing.T) {
+	withFakeProcess(t, func(p *DebuggedProcess, fb *fakeBackend) {
+		g := p.addGoroutine(1, 1)
+
+		addr := uintptr(0x1008)
+		inttyp := &dwarf.IntType{BasicType: dwarf.BasicType{CommonType: dwarf.CommonType{ByteSize: 4, Name: "int32"}}}
+		fb.WriteMemory(addr, []byte{1, 0, 0, 0})
+
+		bp := p.newBreakpoint("", "", 0, uint64(addr), nil)
+		bp.Watch = WatchWrite
+		bp.Expr = "x"
+		bp.watchSize = 4
+		bp.watchType = inttyp
+		bp.watchData = []byte{1, 0, 0, 0}
+		bp.watchValue = "1"
+
+		p.HWBreakpoints[1] = bp
+		assertNoError(p.updateHardwareBreakpoints(), t, "updateHardwareBreakpoints()")
+
+		// DR1 enabled, break on write, 4 bytes long.
+		if fb.dr[1][1] != uint64(addr) || fb.dr[1][7] != 0xd00004 {
+			t.Fatalf("Unexpected debug registers %#v", fb.dr[1])
+		}
+
+		// Writing the same value does not stop on a write watchpoint.
+		fb.dr[1][6] = 2
+		stop, err := p.checkWatchpoints(g)
+		assertNoError(err, t, "checkWatchpoints()")
+		if stop {
+			t.Fatal("Stopped on a write that did not change the value")
+		}
+		if fb.dr[1][6] != 0 {
+			t.Fatal("DR6 not reset")
+		}
+
+		fb.WriteMemory(addr, []byte{2, 0, 0, 0})
+		fb.dr[1][6] = 2
+		stop, err = p.checkWatchpoints(g)
+		assertNoError(err, t, "checkWatchpoints()")
+		if !stop || bp.watchValue != "2" {
+			t.Fatalf("Watchpoint not reported, value: %s", bp.watchValue)
+		}
+
+		_, err = p.ClearWatchpoint(bp.ID)
+		assertNoError(err, t, "ClearWatchpoint()")
+		if p.HWBreakpoints[1] != nil || fb.dr[1][7] != 0 {
+			t.Fatal("Watchpoint not cleared")
+		}
+	})
+}
+
+func TestFakeBreakpointHitCounts(t *testing.T) {
+	withFakeProcess(t, func(p *DebuggedProcess, fb *fakeBackend) {
+		g := p.addGoroutine(1, 1)
+
+		bp, err := p.Break(fakeFuncEntry)
+		assertNoError(err, t, "Break()")
+
+		_, err = p.SetIgnoreCount(bp.ID, 2)
+		assertNoError(err, t, "SetIgnoreCount()")
+
+		for i, expected := range []bool{false, false, true} {
+			if stop := g.breakpointHit(bp); stop != expected {
+				t.Fatalf("Hit %d: expected stop %v got %v", i, expected, stop)
+			}
+		}
+
+		if bp.TotalHitCount != 3 || bp.HitCount[1] != 3 || bp.IgnoreCount != 0 {
+			t.Fatalf("Wrong counts total: %d goroutine 1: %d ignore: %d", bp.TotalHitCount, bp.HitCount[1], bp.IgnoreCount)
+		}
+
+		p.currentGoroutine = g
+		if p.CurrentBreakpoint() != bp {
+			t.Fatal("Stop not attributed to the breakpoint")
+		}
+
+		_, first := p.CurrentBreakpointStop()
+		g.breakpointHit(bp)
+		if _, second := p.CurrentBreakpointStop(); second == first {
+			t.Fatalf("Two stops at breakpoint %d have the same number %d", bp.ID, first)
+		}
+
+		bp.OneShot = true
+		if !g.breakpointHit(bp) {
+			t.Fatal("One-shot breakpoint did not stop")
+		}
+		if len(p.Breakpoints) != 0 {
+			t.Fatal("One-shot breakpoint not cleared")
+		}
+	})
+}
+
+func TestFakeBreakpointSharedWithTemp(t *testing.T) {
+	withFakeProcess(t, func(p *DebuggedProcess, fb *fakeBackend) {
+		g := p.addGoroutine(1, 1)
+
+		bp, err := p.Break(fakeFuncEntry)
+		assertNoError(err, t, "Break()")
+		_, err = p.SetIgnoreCount(bp.ID, 1)
+		assertNoError(err, t, "SetIgnoreCount()")
+
+		//next of goroutine 1 puts its temp breakpoint at the same address
+		_, err = p.setBreakpoint(fakeFuncEntry, g.id)
+		assertNoError(err, t, "setBreakpoint()")
+
+		if !g.stopsAt(bp) {
+			t.Fatal("Goroutine did not stop at its temp breakpoint")
+		}
+		if bp.TotalHitCount != 1 || bp.HitCount[1] != 1 || bp.IgnoreCount != 0 {
+			t.Fatalf("Wrong counts total: %d goroutine 1: %d ignore: %d", bp.TotalHitCount, bp.HitCount[1], bp.IgnoreCount)
+		}
+		if g.breakpoint != nil {
+			t.Fatal("Ignored hit attributed to the user breakpoint")
+		}
+
+		other := p.addGoroutine(2, 2)
+		if !other.stopsAt(bp) || other.breakpoint != bp {
+			t.Fatal("Other goroutine did not stop at the user breakpoint")
+		}
+		if bp.TotalHitCount != 2 {
+			t.Fatalf("Wrong total hit count %d", bp.TotalHitCount)
+		}
+	})
+}
+
+func TestFakeSignalPolicies(t *testing.T) {
+	withFakeProcess(t, func(p *DebuggedProcess, fb *fakeBackend) {
+		if stop, deliver := p.signalReceived(1, syscall.SIGURG, false); stop || deliver != syscall.SIGURG {
+			t.Fatalf("SIGURG: expected nostop pass got stop %v deliver %s", stop, SignalName(deliver))
+		}
+
+		err := p.HandleSignal(syscall.SIGUSR1, SignalPolicy{Print: true})
+		assertNoError(err, t, "HandleSignal()")
+
+		if stop, deliver := p.signalReceived(1, syscall.SIGUSR1, false); stop || deliver != 0 {
+			t.Fatalf("SIGUSR1: expected nostop nopass got stop %v deliver %s", stop, SignalName(deliver))
+		}
+
+		if err := p.HandleSignal(syscall.SIGTRAP, SignalPolicy{}); err == nil {
+			t.Fatal("Policy of SIGTRAP changed")
+		}
+
+		sig, err := ParseSignal("usr2")
+		assertNoError(err, t, "ParseSignal()")
+
+		p.currentGoroutine = p.addGoroutine(1, 1)
+		assertNoError(p.Signal(sig), t, "Signal()")
+
+		if fb.sig[1] != syscall.SIGUSR2 {
+			t.Fatalf("Expected SIGUSR2 on the next resume got %s", SignalName(fb.sig[1]))
+		}
+	})
+}
+
+func TestFakeNext(t *testing.T) {
+	withFakeProcess(t, func(p *DebuggedProcess, fb *fakeBackend) {
+		// Lines 1, 2 and 3 start at the entry, entry+3 and entry+6,
+		// every instruction is a nop.
+		fn := &p.GoSymTable.Funcs[0]
+		fn.LineTable = gosym.NewLineTable([]byte{1, 0x82, 1, 0x82, 1}, fakeFuncEntry)
+		fn.Obj = &gosym.Obj{Paths: []gosym.Sym{{Name: "fake.go", Value: 1}}}
+		for pc := uintptr(fakeFuncEntry); pc < fakeFuncEnd; pc++ {
+			fb.mem[pc] = 0x90
+		}
+
+		// A leaf function, the return address is at the top of the stack.
+		var debugFrame []byte
+		debugFrame = appendFrameEntry(debugFrame, 0xffffffff, []byte{
+			3, 0, 1, 0x78, 16, // version, augmentation, alignments, return address register
+			0x0c, 7, 8, // DW_CFA_def_cfa rsp+8
+			0x90, 1, // DW_CFA_offset r16 cfa-8
+		})
+		var rng [16]byte
+		binary.LittleEndian.PutUint64(rng[:8], fakeFuncEntry)
+		binary.LittleEndian.PutUint64(rng[8:], fakeFuncEnd-fakeFuncEntry)
+		debugFrame = appendFrameEntry(debugFrame, 0, rng[:])
+		p.FrameEntries = frame.Parse(debugFrame)
+
+		regs := fb.regs[1]
+		regs.pc, regs.sp = fakeFuncEntry, 0x2000
+		fb.WriteMemory(0x2000, []byte{0, 0, 0x50, 0, 0, 0, 0, 0})
+
+		// Plays the part of Listen, every resume of the goroutine
+		// single steps a nop.
+		g := p.addGoroutine(1, 1)
+		g.chwait, g.chcont = make(chan struct{}), make(chan *waitarg)
+		go func() {
+			for range g.chwait {
+				if regs.rflags&FLAGS_TF != 0 {
+					regs.pc++
+				}
+				g.chcont <- &waitarg{g.chwait, TE_BREAKPOINT}
+			}
+		}()
+		defer close(g.chwait)
+
+		for _, expected := range []uint64{fakeFuncEntry + 3, fakeFuncEntry + 6} {
+			assertNoError(g.next(), t, "next()")
+			if regs.pc != expected {
+				t.Fatalf("Expected pc %#v got %#v", expected, regs.pc)
+			}
+			if regs.rflags&FLAGS_TF != 0 {
+				t.Fatal("Single step left enabled")
+			}
+		}
+	})
+}
+
+// Appends a .debug_frame record, a CIE if id is 0xffffffff, an FDE of
+// the last CIE otherwise.
+func appendFrameEntry(data []byte, id uint32, body []byte) []byte {
+	var hdr [8]byte
+	binary.LittleEndian.PutUint32(hdr[:4], uint32(len(body)+4))
+	binary.LittleEndian.PutUint32(hdr[4:], id)
+	return append(append(data, hdr[:]...), body...)
+}
@@ -1,7 +1,9 @@
 package proctl
 
 import (
+	"debug/dwarf"
 	"fmt"
+	"go/ast"
 	"log"
 )
 
@@ -15,7 +17,26 @@ type Breakpoint struct {
 	Addr         uint64
 	OriginalData []byte
 	ID           int
-	goroutines   []int //breakpoint belong to those goroutines, -1 means belong to all goroutines
+	Location     string //as given to BreakByLocation, re-resolved on restart
+	goroutines   []int  //breakpoint belong to those goroutines, -1 means belong to all goroutines
+	Cond         string
+	cond         ast.Expr //parsed Cond, the process only stops when it is true
+
+	TotalHitCount int         //stops at the breakpoint
+	HitCount      map[int]int //stops at the breakpoint by goroutine id
+	IgnoreCount   int         //hits left to ignore before stopping again
+	OneShot       bool        //cleared the first time it stops the process
+	Commands      []string    //debugger commands to run when it stops the process
+
+	// Set on watchpoints only, they live in HWBreakpoints
+	// instead of Breakpoints.
+	Watch      WatchType
+	Expr       string
+	watchSize  int
+	watchType  dwarf.Type
+	watchData  []byte //memory at Addr when last reported
+	watchValue string
+	scope      *watchScope
 }
 
 func (bp *Breakpoint) isTemp() bool {
@@ -60,5 +81,288 @@ func (dbp *DebuggedProcess) newBreakpoint(fn, f string, l int, addr uint64, data
 		Addr:         addr,
 		OriginalData: data,
 		ID:           dbp.breakpointIDCounter,
+		HitCount:     make(map[int]int),
+	}
+}
+
+// Gives bp, set again on the relaunched process dbp, the id it had in
+// old so commands naming breakpoints by id keep working. Breakpoints set
+// later are numbered after all of old's.
+func (dbp *DebuggedProcess) RestoreBreakpointID(old *DebuggedProcess, bp *Breakpoint, id int) {
+	bp.ID = id
+	if dbp.breakpointIDCounter < old.breakpointIDCounter {
+		dbp.breakpointIDCounter = old.breakpointIDCounter
+	}
+}
+
+// Called when g hits the user breakpoint bp, evaluates its condition
+// and updates the hit and ignore counts. Reports whether g stops.
+func (g *Goroutine) breakpointHit(bp *Breakpoint) bool {
+	if bp.cond != nil {
+		ok, err := g.evalCondition(bp.cond)
+		if err != nil {
+			//stop, the user has to look at it
+			fmt.Printf("Error evaluating condition of breakpoint %d: %s\n", bp.ID, err)
+		} else if !ok {
+			return false
+		}
+	}
+
+	bp.TotalHitCount++
+	bp.HitCount[g.id]++
+
+	if bp.IgnoreCount > 0 {
+		bp.IgnoreCount--
+		return false
+	}
+
+	if bp.OneShot {
+		if _, err := g.dbp.clearBreakpoint(bp.Addr, -1); err != nil {
+			log.Print(err)
+		}
+	}
+
+	g.dbp.breakpointStops++
+	g.breakpoint, g.breakpointStop = bp, g.dbp.breakpointStops
+	return true
+}
+
+// Called when g traps at bp, reports whether g stops there: for a temp
+// breakpoint of its own, or for the user breakpoint at the same address.
+// The user breakpoint counts the hit and checks its condition even when
+// g stops anyway, e.g. next or stepout put their breakpoint there.
+func (g *Goroutine) stopsAt(bp *Breakpoint) bool {
+	stop := bp.belongsTo(g.id)
+	if bp.belongsTo(-1) && g.breakpointHit(bp) {
+		stop = true
+	}
+	return stop
+}
+
+// Functions between a call to runtime.Breakpoint and its int3.
+var breakpointFunctions = map[string]bool{
+	"runtime.Breakpoint": true,
+	"runtime.breakpoint": true,
+}
+
+// Called when g traps at pc without a breakpoint of the debugger at
+// pc-1. Reports whether g hit an int3 compiled into the program, which
+// the pc is already past. A call to runtime.Breakpoint is run to its
+// return so g stops in the caller.
+func (g *Goroutine) hardcodedBreakpoint(pc uint64) (bool, error) {
+	mem, err := g.dbp.readMemory(uintptr(pc-1), 1)
+	if err != nil {
+		return false, err
+	}
+	if mem[0] != 0xcc {
+		return false, nil
+	}
+
+	if _, _, fn := g.dbp.GoSymTable.PCToLine(pc - 1); fn != nil && breakpointFunctions[fn.Name] {
+		if pc, err = g.returnFromBreakpoint(); err != nil {
+			return false, err
+		}
+	}
+
+	f, l, fn := g.dbp.GoSymTable.PCToLine(pc)
+	if fn == nil {
+		fmt.Printf("Hard-coded breakpoint in goroutine %d at %#v\n", g.id, pc)
+	} else {
+		fmt.Printf("Hard-coded breakpoint in goroutine %d at %s:%d %s\n", g.id, f, l, fn.Name)
+	}
+	return true, nil
+}
+
+// Runs g out of runtime.Breakpoint and returns the pc it stopped at,
+// the return address in the caller unless g stopped somewhere else.
+func (g *Goroutine) returnFromBreakpoint() (uint64, error) {
+	frames, err := g.unwind(len(breakpointFunctions) + 1)
+	if err != nil {
+		return 0, err
+	}
+
+	var ret uint64
+	for _, f := range frames[1:] {
+		if !breakpointFunctions[f.Function] {
+			ret = f.PC
+			break
+		}
+	}
+	if ret == 0 {
+		return 0, fmt.Errorf("could not find the caller of runtime.Breakpoint")
+	}
+
+	if _, err := g.dbp.setBreakpoint(ret, g.id); err != nil {
+		return 0, err
+	}
+	defer func() {
+		if _, err := g.dbp.clearBreakpoint(ret, g.id); err != nil {
+			log.Print(err)
+		}
+	}()
+
+	if err := g.cont(); err != nil {
+		return 0, err
+	}
+	return g.pc()
+}
+
+func (dbp *DebuggedProcess) setBreakpoint(addr uint64, gid int) (*Breakpoint, error) {
+	var f, l, fn = dbp.GoSymTable.PCToLine(uint64(addr))
+	if fn == nil {
+		return nil, InvalidAddressError{address: addr}
+	}
+
+	bp := dbp.Breakpoints[addr]
+	if bp == nil {
+		originalData, err := dbp.readMemory(uintptr(addr), 1)
+		if err != nil {
+			return nil, err
+		}
+
+		_, err = dbp.writeMemory(uintptr(addr), []byte{0xCC})
+		if err != nil {
+			return nil, err
+		}
+
+		bp = dbp.newBreakpoint(fn.Name, f, l, addr, originalData)
+		dbp.Breakpoints[addr] = bp
+	}
+
+	//goroutine scoped breakpoints are counted, a next and a
+	//watchpoint scope may share the same return address
+	if gid != -1 || !bp.belongsTo(gid) {
+		bp.goroutines = append(bp.goroutines, gid)
 	}
+
+	return bp, nil
+}
+
+func (dbp *DebuggedProcess) clearBreakpoint(addr uint64, gid int) (*Breakpoint, error) {
+	// Check for software breakpoint
+	if bp, ok := dbp.Breakpoints[addr]; ok {
+		maxindex := len(bp.goroutines) - 1
+		for i, id := range bp.goroutines {
+			if id == gid {
+				bp.goroutines[i] = bp.goroutines[maxindex]
+				bp.goroutines = bp.goroutines[:maxindex]
+				break
+			}
+		}
+
+		if len(bp.goroutines) == 0 {
+			if _, err := dbp.writeMemory(uintptr(bp.Addr), bp.OriginalData); err != nil {
+				return nil, fmt.Errorf("could not clear breakpoint %s", err)
+			}
+			delete(dbp.Breakpoints, addr)
+		}
+
+		return bp, nil
+	}
+	return nil, fmt.Errorf("No breakpoint currently set for %#v", addr)
+}
+
+const (
+	dr6Trapped = 0xf //DR6 B0-B3, which debug register triggered
+	dr7Enable  = 1   //DR7 L0, shifted by 2 for each register
+	dr7RWShift = 16  //DR7 R/W0 and LEN0, shifted by 4 for each register
+)
+
+// Encodes the R/W and LEN fields of DR7 for the debug register
+// holding bp.
+func dr7Fields(bp *Breakpoint) uint64 {
+	var rw, length uint64
+
+	switch bp.Watch {
+	case WatchWrite:
+		rw = 1
+	default:
+		//x86 has no read only condition, WatchRead filters writes out
+		rw = 3
+	}
+
+	switch bp.watchSize {
+	case 2:
+		length = 1
+	case 4:
+		length = 3
+	case 8:
+		length = 2
+	}
+
+	return rw | length<<2
+}
+
+// Sets the hardware breakpoints of thread tid by setting the contents
+// of the debug registers DR0-DR3 with the addresses in HWBreakpoints.
+// Debug register 7 is the control register.
+func (dbp *DebuggedProcess) setHardwareBreakpoints(tid int) error {
+	var dr7 uint64
+
+	for reg, bp := range dbp.HWBreakpoints {
+		if bp == nil {
+			continue
+		}
+
+		if err := dbp.backend.SetDebugRegister(tid, reg, bp.Addr); err != nil {
+			return fmt.Errorf("could not set debug register %d of thread %d: %s", reg, tid, err)
+		}
+
+		dr7 |= dr7Enable<<uint(reg*2) | dr7Fields(bp)<<uint(dr7RWShift+reg*4)
+	}
+
+	if err := dbp.backend.SetDebugRegister(tid, 7, dr7); err != nil {
+		return fmt.Errorf("could not set debug control register of thread %d: %s", tid, err)
+	}
+
+	dbp.hwThreads[tid] = true
+	return nil
+}
+
+// Programs every thread after HWBreakpoints changed.
+func (dbp *DebuggedProcess) updateHardwareBreakpoints() error {
+	ths, err := dbp.getThreads()
+	if err != nil {
+		return err
+	}
+
+	dbp.hwThreads = make(map[int]bool)
+	for _, tid := range ths {
+		if err := dbp.setHardwareBreakpoints(tid); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Programs thread tid if it was created after HWBreakpoints last
+// changed. Threads don't inherit debug registers, this must run
+// before a new thread is resumed.
+func (dbp *DebuggedProcess) syncDebugRegisters(tid int) error {
+	if dbp.hwThreads[tid] || !dbp.hasHardwareBreakpoints() {
+		return nil
+	}
+
+	return dbp.setHardwareBreakpoints(tid)
+}
+
+func (dbp *DebuggedProcess) hasHardwareBreakpoints() bool {
+	for _, bp := range dbp.HWBreakpoints {
+		if bp != nil {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Reports whether DR6 says one of the watchpoints triggered.
+func (dbp *DebuggedProcess) watchpointTrapped(dr6 uint64) bool {
+	for reg, bp := range dbp.HWBreakpoints {
+		if bp != nil && dr6&(1<<uint(reg)) != 0 {
+			return true
+		}
+	}
+	return false
 }
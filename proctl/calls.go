@@ -0,0 +1,230 @@
+package proctl
+
+import (
+	"debug/dwarf"
+	"debug/gosym"
+	"encoding/binary"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"strings"
+)
+
+// A parameter or result of a function.
+type funcParam struct {
+	name string
+	typ  dwarf.Type
+}
+
+// Calls the function of the call expression expr on the current
+// goroutine and returns its results. The runtime of the program runs
+// the call, it refuses to when the goroutine is not stopped at a safe
+// point, e.g. in the middle of a function prologue.
+func (dbp *DebuggedProcess) Call(expr string) ([]*Variable, error) {
+	if dbp.core {
+		return nil, ErrCoreFile
+	}
+
+	if dbp.selectedGoroutine != nil {
+		return nil, fmt.Errorf("functions can only be called on goroutine %d, goroutine %d is selected", dbp.currentGoroutine.id, dbp.selectedGoroutine.id)
+	}
+
+	t, err := parser.ParseExpr(expr)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse %q: %s", expr, err)
+	}
+	ce, ok := t.(*ast.CallExpr)
+	if !ok {
+		return nil, fmt.Errorf("%s is not a function call", expr)
+	}
+	if ce.Ellipsis.IsValid() {
+		return nil, fmt.Errorf("variadic calls are not supported")
+	}
+
+	g := dbp.currentGoroutine
+	fn, recv, err := g.callTarget(ce.Fun)
+	if err != nil {
+		return nil, err
+	}
+
+	params, results, err := dbp.functionParams(fn.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	args := ce.Args
+	if recv != nil {
+		args = append([]ast.Expr{recv}, args...)
+	}
+	if len(args) != len(params) {
+		n := len(params)
+		if recv != nil {
+			n--
+		}
+		return nil, fmt.Errorf("%s takes %d arguments, got %d", fn.Name, n, len(ce.Args))
+	}
+
+	values := make([][]byte, len(params))
+	for i, p := range params {
+		if recv != nil && i == 0 {
+			values[i], err = g.receiverArgument(recv, p.typ)
+		} else {
+			//the address of a variable on the stack may change
+			//while the call runs
+			values[i], err = g.encodeExpr(args[i], p.typ, false)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("argument %s: %s", p.name, err)
+		}
+	}
+
+	return g.callFunction(fn, params, results, values)
+}
+
+// Resolves the function of a call, for method calls the receiver
+// expression is returned as well.
+func (g *Goroutine) callTarget(fun ast.Expr) (*gosym.Func, ast.Expr, error) {
+	name, err := exprName(fun)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if fn := g.dbp.GoSymTable.LookupFunc(name); fn != nil {
+		return fn, nil, nil
+	}
+	if fn := g.dbp.GoSymTable.LookupFunc("main." + name); fn != nil {
+		return fn, nil, nil
+	}
+
+	sel, ok := fun.(*ast.SelectorExpr)
+	if !ok {
+		return nil, nil, fmt.Errorf("could not find function %s", name)
+	}
+	_, typ, _, err := g.lvalue(sel.X)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not find function %s", name)
+	}
+
+	tname := strings.TrimPrefix(typ.String(), "*")
+	if i := strings.LastIndex(tname, "."); i >= 0 {
+		for _, m := range []string{
+			tname[:i] + ".(*" + tname[i+1:] + ")." + sel.Sel.Name,
+			tname + "." + sel.Sel.Name,
+		} {
+			if fn := g.dbp.GoSymTable.LookupFunc(m); fn != nil {
+				return fn, sel.X, nil
+			}
+		}
+	}
+
+	return nil, nil, fmt.Errorf("%s has no method %s", exprString(sel.X), sel.Sel.Name)
+}
+
+// Returns the parameters and results of the named function as its
+// DWARF entry lists them, the receiver of a method comes first.
+func (dbp *DebuggedProcess) functionParams(name string) ([]funcParam, []funcParam, error) {
+	rdr := dbp.Dwarf.Reader()
+	for entry, err := rdr.Next(); entry != nil; entry, err = rdr.Next() {
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if entry.Tag != dwarf.TagSubprogram {
+			continue
+		}
+		if n, _ := entry.Val(dwarf.AttrName).(string); n != name {
+			rdr.SkipChildren()
+			continue
+		}
+
+		var params, results []funcParam
+		for child, err := rdr.Next(); child != nil && child.Tag != 0; child, err = rdr.Next() {
+			if err != nil {
+				return nil, nil, err
+			}
+
+			if child.Tag != dwarf.TagFormalParameter {
+				if child.Children {
+					rdr.SkipChildren()
+				}
+				continue
+			}
+
+			offset, ok := child.Val(dwarf.AttrType).(dwarf.Offset)
+			if !ok {
+				return nil, nil, fmt.Errorf("type assertion failed")
+			}
+			typ, err := dbp.Dwarf.Type(offset)
+			if err != nil {
+				return nil, nil, err
+			}
+
+			n, _ := child.Val(dwarf.AttrName).(string)
+			if isResultEntry(child) {
+				results = append(results, funcParam{n, typ})
+			} else {
+				params = append(params, funcParam{n, typ})
+			}
+		}
+		return params, results, nil
+	}
+
+	return nil, nil, fmt.Errorf("could not find the parameters of %s", name)
+}
+
+// Returns the bytes of the receiver of a method call, the variable is
+// addressed or dereferenced as the method needs it like Go does.
+func (g *Goroutine) receiverArgument(expr ast.Expr, typ dwarf.Type) ([]byte, error) {
+	addr, vt, inFrame, err := g.lvalue(expr)
+	if err != nil {
+		return nil, err
+	}
+	name := exprString(expr)
+
+	switch {
+	case vt.String() == typ.String():
+		return g.dbp.readMemory(uintptr(addr), int(typ.Size()))
+	case "*"+vt.String() == typ.String():
+		if inFrame {
+			return nil, fmt.Errorf("can not call a pointer method on %s, it lives on the stack of goroutine %d", name, g.id)
+		}
+		return addressBytes(addr), nil
+	case vt.String() == "*"+typ.String():
+		ptr, err := g.dbp.readUint64(addr)
+		if err != nil {
+			return nil, err
+		}
+		if ptr == 0 {
+			return nil, fmt.Errorf("%s is nil", name)
+		}
+		return g.dbp.readMemory(uintptr(ptr), int(typ.Size()))
+	}
+
+	return nil, fmt.Errorf("can not use %s (type %s) as receiver of type %s", name, vt, typ)
+}
+
+// Returns the name of a variable or function expression, e.g. main.x.
+func exprName(expr ast.Expr) (string, error) {
+	switch e := expr.(type) {
+	case *ast.Ident:
+		return e.Name, nil
+	case *ast.SelectorExpr:
+		return selectorName(e)
+	}
+
+	return "", fmt.Errorf("unsupported expression %T", expr)
+}
+
+func paramTypes(params []funcParam) []dwarf.Type {
+	types := make([]dwarf.Type, len(params))
+	for i, p := range params {
+		types[i] = p.typ
+	}
+	return types
+}
+
+func addressBytes(addr uint64) []byte {
+	buf := make([]byte, ptrsize)
+	binary.LittleEndian.PutUint64(buf, addr)
+	return buf
+}
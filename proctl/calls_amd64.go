@@ -0,0 +1,174 @@
+package proctl
+
+import (
+	"debug/dwarf"
+)
+
+// Registers of the register based calling convention of Go 1.17+,
+// integers in RAX, RBX, RCX, RDI, RSI, R8, R9, R10, R11 and floats in
+// X0 to X14.
+const (
+	intArgRegs   = 9
+	floatArgRegs = 15
+)
+
+// Part of a value passed in a register.
+type regPiece struct {
+	float  bool
+	reg    int   //index of the integer or floating point register
+	offset int64 //of the part in the value
+	size   int64
+}
+
+// Where a parameter of a call is passed. Values passed in registers
+// have a slot at offset in the frame all the same, the callee spills
+// arguments there and results are copied there to be read.
+type paramLoc struct {
+	typ    dwarf.Type
+	regs   []regPiece
+	offset int64
+}
+
+// Layout of the arguments and results of a call, offsets are relative
+// to the stack pointer at the call.
+type callFrame struct {
+	args, results []paramLoc
+	size          int64
+}
+
+// Lays out a call with the given argument and result types, following
+// the register ABI when regabi is set and passing everything on the
+// stack otherwise.
+func newCallFrame(args, results []dwarf.Type, regabi bool) *callFrame {
+	frame := &callFrame{
+		args:    make([]paramLoc, len(args)),
+		results: make([]paramLoc, len(results)),
+	}
+
+	var offset int64
+	assign := func(locs []paramLoc, types []dwarf.Type) {
+		a := &regAssigner{}
+		for i, t := range types {
+			locs[i].typ = t
+			if regabi && a.assignValue(t) {
+				locs[i].regs, a.pieces = a.pieces, nil
+				continue
+			}
+			offset = alignTo(offset, typeAlign(t))
+			locs[i].offset = offset
+			offset += t.Size()
+		}
+		offset = alignTo(offset, int64(ptrsize))
+	}
+	assign(frame.args, args)
+	assign(frame.results, results)
+
+	//spill slots of register arguments, then slots to copy register
+	//results to
+	for _, locs := range [][]paramLoc{frame.args, frame.results} {
+		for i := range locs {
+			if locs[i].regs == nil {
+				continue
+			}
+			offset = alignTo(offset, typeAlign(locs[i].typ))
+			locs[i].offset = offset
+			offset += locs[i].typ.Size()
+		}
+		offset = alignTo(offset, int64(ptrsize))
+	}
+
+	frame.size = offset
+	return frame
+}
+
+// Assigns values to argument registers, a value is passed in registers
+// only when all of its parts fit.
+type regAssigner struct {
+	ints, floats int
+	pieces       []regPiece
+}
+
+func (a *regAssigner) assignValue(t dwarf.Type) bool {
+	if t.Size() == 0 {
+		return false
+	}
+
+	ints, floats := a.ints, a.floats
+	if !a.assign(t, 0) {
+		a.ints, a.floats, a.pieces = ints, floats, nil
+		return false
+	}
+	return true
+}
+
+func (a *regAssigner) assign(t dwarf.Type, offset int64) bool {
+	switch t := resolveTypedef(t).(type) {
+	case *dwarf.IntType, *dwarf.UintType, *dwarf.BoolType, *dwarf.PtrType, *dwarf.CharType, *dwarf.UcharType:
+		return a.assignInt(offset, t.Size())
+	case *dwarf.FloatType:
+		return a.assignFloat(offset, t.Size())
+	case *dwarf.ComplexType:
+		return a.assignFloat(offset, t.Size()/2) && a.assignFloat(offset+t.Size()/2, t.Size()/2)
+	case *dwarf.StructType:
+		for _, f := range t.Field {
+			if !a.assign(f.Type, offset+f.ByteOffset) {
+				return false
+			}
+		}
+		return true
+	case *dwarf.ArrayType:
+		switch t.Count {
+		case 0:
+			return true
+		case 1:
+			return a.assign(t.Type, offset)
+		}
+	}
+
+	return false
+}
+
+func (a *regAssigner) assignInt(offset, size int64) bool {
+	if a.ints == intArgRegs || size > 8 {
+		return false
+	}
+	a.pieces = append(a.pieces, regPiece{reg: a.ints, offset: offset, size: size})
+	a.ints++
+	return true
+}
+
+func (a *regAssigner) assignFloat(offset, size int64) bool {
+	if a.floats == floatArgRegs || size > 8 {
+		return false
+	}
+	a.pieces = append(a.pieces, regPiece{float: true, reg: a.floats, offset: offset, size: size})
+	a.floats++
+	return true
+}
+
+// Returns the alignment Go uses for values of type t.
+func typeAlign(t dwarf.Type) int64 {
+	switch t := resolveTypedef(t).(type) {
+	case *dwarf.StructType:
+		var align int64 = 1
+		for _, f := range t.Field {
+			if a := typeAlign(f.Type); a > align {
+				align = a
+			}
+		}
+		return align
+	case *dwarf.ArrayType:
+		return typeAlign(t.Type)
+	case *dwarf.ComplexType:
+		return t.Size() / 2
+	}
+
+	if size := t.Size(); size > 0 && size < int64(ptrsize) {
+		return size
+	}
+	return int64(ptrsize)
+}
+
+func alignTo(offset, align int64) int64 {
+	return (offset + align - 1) &^ (align - 1)
+}
@@ -0,0 +1,324 @@
+package proctl
+
+import (
+	"debug/gosym"
+	"encoding/binary"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// Status the runtime reports at each trap of the debug call protocol,
+// see runtime.debugCallV2.
+const (
+	debugCallFrameReady = 0
+	debugCallReturned   = 1
+	debugCallPanicked   = 2
+	debugCallUnsafe     = 8
+	debugCallRestore    = 16
+
+	//stack the protocol uses below the stack pointer
+	debugCallStack = 256
+)
+
+// Makes the runtime call fn on the thread of g with the encoded
+// arguments in values. The runtime runs the call on a goroutine of its
+// own, its stops are reported as stops of g, and puts g back where it
+// was once the call is done.
+func (g *Goroutine) callFunction(fn *gosym.Func, params, results []funcParam, values [][]byte) ([]*Variable, error) {
+	dbp := g.dbp
+
+	regabi := true
+	debugCall := dbp.GoSymTable.LookupFunc("runtime.debugCallV2")
+	if debugCall == nil {
+		//Go 1.11 to 1.16 pass everything on the stack
+		regabi = false
+		debugCall = dbp.GoSymTable.LookupFunc("runtime.debugCallV1")
+	}
+	if debugCall == nil {
+		return nil, fmt.Errorf("calling functions needs a program built with Go 1.11 or later")
+	}
+
+	frame := newCallFrame(paramTypes(params), paramTypes(results), regabi)
+
+	regs, err := dbp.registers(g.tid)
+	if err != nil {
+		return nil, err
+	}
+	saved := *regs.(*Regs)
+	if err := g.callPrecheck(saved.Rsp); err != nil {
+		return nil, err
+	}
+	fpregs, err := dbp.fpRegisters(g.tid)
+	if err != nil {
+		return nil, err
+	}
+
+	//push the current pc as if it called debugCall, the frame size
+	//goes below it
+	r := saved
+	r.Rsp -= 8
+	if _, err := dbp.writeMemory(uintptr(r.Rsp), addressBytes(saved.Rip)); err != nil {
+		return nil, err
+	}
+	if _, err := dbp.writeMemory(uintptr(r.Rsp-16), addressBytes(uint64(frame.size))); err != nil {
+		return nil, err
+	}
+	r.Rip = debugCall.Entry
+	r.Orig_rax = ^uint64(0) //do not restart the syscall the thread was in
+	if err := dbp.setRegisters(g.tid, &r); err != nil {
+		return nil, err
+	}
+
+	g.calling = true
+	defer func() { g.calling = false }()
+
+	var (
+		vars    []*Variable
+		callErr error
+	)
+	for {
+		if err := g.cont(); err != nil && err != ErrInterrupt {
+			return nil, err
+		}
+
+		regs, err := dbp.registers(g.tid)
+		if err != nil {
+			return nil, err
+		}
+		r := *regs.(*Regs)
+
+		if !dbp.isDebugCallTrap(r.Rip) {
+			//a breakpoint or signal in the called function, the
+			//call goes on
+			if _, ok := dbp.Breakpoints[r.Rip]; ok {
+				if err := g.step(); err != nil {
+					return nil, err
+				}
+			}
+			continue
+		}
+
+		status := r.R12
+		if !regabi {
+			status = r.Rax
+		}
+		log.Printf("debug call status %d", status)
+
+		switch status {
+		case debugCallFrameReady:
+			fp, err := dbp.fpRegisters(g.tid)
+			if err != nil {
+				return nil, err
+			}
+			if err := g.writeCallArgs(frame, values, &r, fp); err != nil {
+				return nil, err
+			}
+			if err := dbp.setFpRegisters(g.tid, fp); err != nil {
+				return nil, err
+			}
+
+			//return to the trap that reports the results
+			r.Rsp -= 8
+			if _, err := dbp.writeMemory(uintptr(r.Rsp), addressBytes(r.Rip)); err != nil {
+				return nil, err
+			}
+			r.Rip = fn.Entry
+			if err := dbp.setRegisters(g.tid, &r); err != nil {
+				return nil, err
+			}
+		case debugCallReturned:
+			vars, callErr = g.callResults(frame, results, &r)
+		case debugCallPanicked:
+			//the call is not over before the registers are restored,
+			//errors reading the value do not end it
+			v, err := g.readInterface(r.Rsp)
+			if err != nil {
+				v = fmt.Sprintf("(could not read the value: %s)", err)
+			}
+			callErr = fmt.Errorf("%s panicked: %s", fn.Name, v)
+		case debugCallUnsafe:
+			reason, err := g.readString(uintptr(r.Rsp))
+			if err != nil {
+				reason = err.Error()
+			}
+			callErr = fmt.Errorf("can not call %s here: %s", fn.Name, reason)
+		case debugCallRestore:
+			//the runtime pops the registers it saved and returns to
+			//the pc pushed above
+			s := saved
+			s.Rip, s.Rsp = r.Rip, r.Rsp
+			if err := dbp.setRegisters(g.tid, &s); err != nil {
+				return nil, err
+			}
+			if err := dbp.setFpRegisters(g.tid, fpregs); err != nil {
+				return nil, err
+			}
+			if err := g.finishCall(saved.Rip); err != nil {
+				return nil, err
+			}
+			return vars, callErr
+		default:
+			return nil, fmt.Errorf("unexpected debug call status %d", status)
+		}
+	}
+}
+
+// Checks what the runtime can not check itself before a call is
+// injected: the thread runs g on its stack, with room for the protocol
+// below the stack pointer.
+func (g *Goroutine) callPrecheck(sp uint64) error {
+	gaddr, err := g.dbp.tlsG(g.tid)
+	if err != nil {
+		return err
+	}
+	if gaddr == 0 {
+		return fmt.Errorf("thread %d runs no goroutine", g.tid)
+	}
+
+	layouts, err := g.dbp.runtimeLayouts()
+	if err != nil {
+		return err
+	}
+	data, err := layouts.g.read(g.dbp, gaddr)
+	if err != nil {
+		return err
+	}
+
+	goid, err := layouts.g.uint(data, "goid")
+	if err != nil {
+		return err
+	}
+	if goid == 0 {
+		return fmt.Errorf("thread %d is on the system stack (g0), functions can only be called on a goroutine stack", g.tid)
+	}
+
+	status, err := layouts.g.uint(data, "atomicstatus")
+	if err != nil {
+		return err
+	}
+	if status&^gScan != gRunning {
+		return fmt.Errorf("goroutine %d is %s, functions can only be called on a running goroutine", goid, gStatusNames[status&^gScan])
+	}
+
+	stack, err := layouts.g.bytes(data, "stack")
+	if err != nil {
+		return err
+	}
+	lo, err := layouts.stack.uint(stack, "lo")
+	if err != nil {
+		return err
+	}
+	if sp < lo+debugCallStack {
+		return fmt.Errorf("goroutine %d has too little stack left for a call", goid)
+	}
+
+	return nil
+}
+
+// Reports whether the thread stopped at pc at one of the traps of the
+// debug call protocol.
+func (dbp *DebuggedProcess) isDebugCallTrap(pc uint64) bool {
+	//the frames of the call, debugCall32 to debugCall65536, are
+	//assembly local symbols without the package prefix
+	fn := dbp.GoSymTable.PCToFunc(pc - 1)
+	if fn == nil || !strings.HasPrefix(strings.TrimPrefix(fn.Name, "runtime."), "debugCall") {
+		return false
+	}
+
+	mem, err := dbp.readMemory(uintptr(pc-1), 1)
+	return err == nil && mem[0] == 0xcc
+}
+
+// Writes the arguments of a call to the frame at the stack pointer of
+// r and to the argument registers.
+func (g *Goroutine) writeCallArgs(frame *callFrame, values [][]byte, r *Regs, fpregs []byte) error {
+	for i, loc := range frame.args {
+		if loc.regs == nil {
+			if len(values[i]) == 0 {
+				continue
+			}
+			if _, err := g.dbp.writeMemory(uintptr(r.Rsp+uint64(loc.offset)), values[i]); err != nil {
+				return err
+			}
+			continue
+		}
+
+		for _, p := range loc.regs {
+			part := make([]byte, 8)
+			copy(part, values[i][p.offset:p.offset+p.size])
+			if p.float {
+				copy(fpregs[xmmOffset+16*p.reg:], part)
+			} else {
+				*intArgReg(r, p.reg) = binary.LittleEndian.Uint64(part)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Reads the results of a call that returned, register results are
+// copied to their slots in the frame first.
+func (g *Goroutine) callResults(frame *callFrame, results []funcParam, r *Regs) ([]*Variable, error) {
+	var fpregs []byte
+
+	vars := make([]*Variable, 0, len(results))
+	for i, loc := range frame.results {
+		addr := r.Rsp + uint64(loc.offset)
+
+		if loc.regs != nil {
+			val := make([]byte, loc.typ.Size())
+			for _, p := range loc.regs {
+				part := make([]byte, 8)
+				if p.float {
+					if fpregs == nil {
+						var err error
+						if fpregs, err = g.dbp.fpRegisters(g.tid); err != nil {
+							return nil, err
+						}
+					}
+					copy(part, fpregs[xmmOffset+16*p.reg:])
+				} else {
+					binary.LittleEndian.PutUint64(part, *intArgReg(r, p.reg))
+				}
+				copy(val[p.offset:], part[:p.size])
+			}
+			if _, err := g.dbp.writeMemory(uintptr(addr), val); err != nil {
+				return nil, err
+			}
+		}
+
+		value, err := g.extractValue(nil, int64(addr), loc.typ)
+		if err != nil {
+			return nil, err
+		}
+		vars = append(vars, &Variable{results[i].name, value, loc.typ.String()})
+	}
+
+	return vars, nil
+}
+
+// Runs g back to pc, where the call was made. A breakpoint there has
+// its original instruction in place since g stopped at it, the trap is
+// inserted for the way back.
+func (g *Goroutine) finishCall(pc uint64) error {
+	if _, err := g.dbp.setBreakpoint(pc, g.id); err != nil {
+		return err
+	}
+	defer func() {
+		if _, err := g.dbp.clearBreakpoint(pc, g.id); err != nil {
+			log.Print(err)
+		}
+	}()
+
+	if _, err := g.dbp.writeMemory(uintptr(pc), []byte{0xcc}); err != nil {
+		return err
+	}
+
+	return g.cont()
+}
+
+func intArgReg(r *Regs, i int) *uint64 {
+	return [intArgRegs]*uint64{&r.Rax, &r.Rbx, &r.Rcx, &r.Rdi, &r.Rsi, &r.R8, &r.R9, &r.R10, &r.R11}[i]
+}
@@ -0,0 +1,55 @@
+package proctl
+
+import (
+	"fmt"
+	"log"
+	"syscall"
+)
+
+// A copy of the process forked by Checkpoint, kept stopped until the
+// session is restarted from it.
+type Checkpoint struct {
+	ID       int
+	Pid      int
+	Note     string
+	PC       uint64
+	File     string
+	Line     int
+	Function string
+
+	//threads of the process the checkpoint does not have, goroutines
+	//running or blocked on them may never run again after a restart
+	LostThreads int
+}
+
+// Returns the checkpoint with the given id.
+func (dbp *DebuggedProcess) FindCheckpoint(id int) (*Checkpoint, error) {
+	for _, cp := range dbp.checkpoints {
+		if cp.ID == id {
+			return cp, nil
+		}
+	}
+
+	return nil, fmt.Errorf("No checkpoint %d", id)
+}
+
+func (dbp *DebuggedProcess) PrintCheckpoints() {
+	for _, cp := range dbp.checkpoints {
+		fmt.Printf("%d\tpid %d\t%#v\t%s:%d\t%s", cp.ID, cp.Pid, cp.PC, cp.File, cp.Line, cp.Function)
+		if cp.Note != "" {
+			fmt.Printf("\t%s", cp.Note)
+		}
+		fmt.Println()
+	}
+}
+
+// Kills the processes of all checkpoints, they are of no use once the
+// session ends or the program is relaunched.
+func (dbp *DebuggedProcess) KillCheckpoints() {
+	for _, cp := range dbp.checkpoints {
+		if err := syscall.Kill(cp.Pid, syscall.SIGKILL); err != nil {
+			log.Printf("could not kill checkpoint %d: %s", cp.ID, err)
+		}
+	}
+	dbp.checkpoints = nil
+}
@@ -0,0 +1,196 @@
+package proctl
+
+import (
+	"fmt"
+	"log"
+	"syscall"
+	"time"
+)
+
+var syscallInstr = []byte{0x0f, 0x05}
+
+// Forks the process from the thread of the current goroutine and keeps
+// the child stopped, the session can be restarted from it later. Only
+// the forking thread exists in the child, the Go scheduler keeps working
+// there when the program runs with GOMAXPROCS=1. With more threads a
+// goroutine left on one of the others, or the scheduler waiting for
+// them, can deadlock the child, LostThreads tells how many are missing.
+func (dbp *DebuggedProcess) Checkpoint(note string) (*Checkpoint, error) {
+	if dbp.core {
+		return nil, ErrCoreFile
+	}
+
+	b, ok := dbp.backend.(*ptraceBackend)
+	if !ok {
+		return nil, fmt.Errorf("checkpoints are not supported by this backend")
+	}
+
+	g := dbp.currentGoroutine
+	pc, err := g.pc()
+	if err != nil {
+		return nil, err
+	}
+
+	ths, err := dbp.getThreads()
+	if err != nil {
+		return nil, err
+	}
+
+	pid, err := b.fork(g)
+	if err != nil {
+		return nil, err
+	}
+
+	dbp.checkpointIDCounter++
+	f, l, fn := dbp.GoSymTable.PCToLine(pc)
+	cp := &Checkpoint{
+		ID:   dbp.checkpointIDCounter,
+		Pid:  pid,
+		Note: note,
+		PC:   pc,
+		File: f,
+		Line: l,
+
+		LostThreads: len(ths) - 1,
+	}
+	if fn != nil {
+		cp.Function = fn.Name
+	}
+
+	dbp.checkpoints = append(dbp.checkpoints, cp)
+	return cp, nil
+}
+
+// Makes the thread of g call fork(2) and returns the pid of the child.
+// The child is left stopped with the registers g had and the original
+// instructions under the breakpoints.
+func (b *ptraceBackend) fork(g *Goroutine) (int, error) {
+	regs, err := b.Registers(g.tid)
+	if err != nil {
+		return 0, err
+	}
+	saved := *regs.(*Regs)
+
+	orig, err := b.ReadMemory(uintptr(saved.Rip), len(syscallInstr))
+	if err != nil {
+		return 0, err
+	}
+	if _, err := b.WriteMemory(uintptr(saved.Rip), syscallInstr); err != nil {
+		return 0, err
+	}
+	defer func() {
+		if _, err := b.WriteMemory(uintptr(saved.Rip), orig); err != nil {
+			log.Print(err)
+		}
+		if err := b.SetRegisters(g.tid, &saved); err != nil {
+			log.Print(err)
+		}
+	}()
+
+	if err := setTraceOptions(g.tid, syscall.PTRACE_O_TRACECLONE|syscall.PTRACE_O_TRACEFORK); err != nil {
+		return 0, err
+	}
+	defer func() {
+		if err := setTraceOptions(g.tid, syscall.PTRACE_O_TRACECLONE); err != nil {
+			log.Print(err)
+		}
+	}()
+
+	r := saved
+	r.Rax = syscall.SYS_FORK
+	r.Orig_rax = ^uint64(0) //do not restart the syscall the thread was in
+	r.Eflags |= FLAGS_TF
+	if err := b.SetRegisters(g.tid, &r); err != nil {
+		return 0, err
+	}
+
+	//single step over the syscall
+	b.setForking(g.tid)
+	defer b.setForking(0)
+	g.lastPC = 0
+	if err := g.cont(); err != nil {
+		return 0, err
+	}
+
+	regs, err = b.Registers(g.tid)
+	if err != nil {
+		return 0, err
+	}
+	if regs.PC() != saved.Rip+uint64(len(syscallInstr)) {
+		return 0, fmt.Errorf("the fork was interrupted at %#v", regs.PC())
+	}
+	ret := int64(regs.(*Regs).Rax)
+	if ret < 0 {
+		return 0, fmt.Errorf("fork failed: %s", syscall.Errno(-ret))
+	}
+	pid := int(ret)
+
+	if err := b.waitForked(pid); err != nil {
+		return 0, err
+	}
+
+	//the child is a copy of the process as the debugger patched it
+	if err := pokeData(pid, uintptr(saved.Rip), orig); err != nil {
+		return 0, err
+	}
+	for addr, bp := range b.dbp.Breakpoints {
+		if err := pokeData(pid, uintptr(addr), bp.OriginalData); err != nil {
+			return 0, err
+		}
+	}
+
+	return pid, b.SetRegisters(pid, &saved)
+}
+
+// Waits until waitroutine sees the forked process pid stop.
+func (b *ptraceBackend) waitForked(pid int) error {
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case child := <-b.forked:
+			if child == pid {
+				return nil
+			}
+			log.Printf("unexpected forked process %d", child)
+		case <-timeout:
+			return fmt.Errorf("forked process %d did not stop", pid)
+		}
+	}
+}
+
+// Continues the session in checkpoint id once the process of dbp is
+// gone, see Kill. The returned process has no breakpoints, the caller
+// sets them again, and keeps the other checkpoints. The checkpoint is
+// used up, take another one to come back to the same state again.
+func (dbp *DebuggedProcess) RestartCheckpoint(id int) (*DebuggedProcess, error) {
+	cp, err := dbp.FindCheckpoint(id)
+	if err != nil {
+		return nil, err
+	}
+
+	newdbp, err := traceProcess(cp.Pid, -1)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, c := range dbp.checkpoints {
+		if c != cp {
+			newdbp.checkpoints = append(newdbp.checkpoints, c)
+		}
+	}
+	newdbp.checkpointIDCounter = dbp.checkpointIDCounter
+
+	return newdbp, nil
+}
+
+func setTraceOptions(tid, options int) error {
+	var err error
+	execPtraceFunc(func() { err = syscall.PtraceSetOptions(tid, options) })
+	return err
+}
+
+func pokeData(pid int, addr uintptr, data []byte) error {
+	var err error
+	execPtraceFunc(func() { _, err = syscall.PtracePokeData(pid, addr, data) })
+	return err
+}
@@ -0,0 +1,284 @@
+package proctl
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"syscall"
+)
+
+const (
+	NT_PRSTATUS = 1
+	NT_PRPSINFO = 3
+
+	prstatusPidOffset = 32  // elf_prstatus.pr_pid
+	prstatusRegOffset = 112 // elf_prstatus.pr_reg
+	prpsinfoPidOffset = 24  // elf_prpsinfo.pr_pid
+)
+
+// A contiguous range of the address space of a core file,
+// backed by a PT_LOAD segment of the core or of the executable.
+type coreSegment struct {
+	start, end uint64
+	r          io.ReaderAt
+}
+
+// Registers of a thread in a core file, they can't be changed.
+type coreRegs struct {
+	regs syscall.PtraceRegs
+}
+
+func (r *coreRegs) PC() uint64 {
+	return r.regs.Rip
+}
+
+func (r *coreRegs) SP() uint64 {
+	return r.regs.Rsp
+}
+
+func (r *coreRegs) SetPC(tid int, pc uint64) error {
+	return ErrCoreFile
+}
+
+func (r *coreRegs) Rflags() uint64 {
+	return r.regs.Eflags
+}
+
+func (r *coreRegs) SetRflags(tid int, rflags uint64) error {
+	return ErrCoreFile
+}
+
+// Read-only backend serving memory and registers from an ELF core file.
+type coreBackend struct {
+	segments []coreSegment
+	threads  []int
+	regs     map[int]*coreRegs
+	chTrap   chan *trapEvent
+}
+
+// Opens the core file core of executable exe and returns a read-only
+// DebuggedProcess stopped on the thread that received the fatal signal.
+func OpenCore(exe, core string) (*DebuggedProcess, error) {
+	corefile, err := elf.Open(core)
+	if err != nil {
+		return nil, err
+	}
+
+	if corefile.Type != elf.ET_CORE {
+		return nil, fmt.Errorf("%s is not a core file", core)
+	}
+
+	b := &coreBackend{
+		regs:   make(map[int]*coreRegs),
+		chTrap: make(chan *trapEvent, 1),
+	}
+
+	pid, err := b.readNotes(corefile)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(b.threads) == 0 {
+		return nil, fmt.Errorf("%s contains no threads", core)
+	}
+
+	dbp := newDebuggedProcess(pid)
+	dbp.backend = b
+	dbp.core = true
+
+	exefile, err := dbp.openExecutable(exe)
+	if err != nil {
+		return nil, err
+	}
+
+	// Memory saved in the core takes precedence, read only mappings that
+	// the kernel did not dump are taken from the executable.
+	b.addSegments(corefile)
+	b.addSegments(exefile.File)
+
+	dbp.parseExecutable(exefile)
+
+	//the first thread is the one that received the fatal signal
+	b.chTrap <- &trapEvent{
+		gid: -1,
+		tid: b.threads[0],
+		typ: TE_MANUAL,
+	}
+
+	return dbp, nil
+}
+
+func (b *coreBackend) addSegments(f *elf.File) {
+	for _, prog := range f.Progs {
+		if prog.Type != elf.PT_LOAD || prog.Filesz == 0 {
+			continue
+		}
+
+		b.segments = append(b.segments, coreSegment{
+			start: prog.Vaddr,
+			end:   prog.Vaddr + prog.Filesz,
+			r:     prog,
+		})
+	}
+}
+
+// Reads the NT_PRSTATUS notes of every thread and returns the pid
+// recorded in the NT_PRPSINFO note.
+func (b *coreBackend) readNotes(f *elf.File) (int, error) {
+	pid := 0
+
+	for _, prog := range f.Progs {
+		if prog.Type != elf.PT_NOTE {
+			continue
+		}
+
+		data := make([]byte, prog.Filesz)
+		if _, err := prog.ReadAt(data, 0); err != nil {
+			return 0, err
+		}
+
+		for len(data) >= 12 {
+			namesz := alignNote(binary.LittleEndian.Uint32(data[0:4]))
+			descsz := binary.LittleEndian.Uint32(data[4:8])
+			typ := binary.LittleEndian.Uint32(data[8:12])
+
+			data = data[12:]
+			if uint32(len(data)) < namesz+descsz {
+				return 0, fmt.Errorf("malformed note segment")
+			}
+			desc := data[namesz : namesz+descsz]
+			if next := namesz + alignNote(descsz); next < uint32(len(data)) {
+				data = data[next:]
+			} else {
+				data = nil
+			}
+
+			switch typ {
+			case NT_PRSTATUS:
+				regsize := binary.Size(syscall.PtraceRegs{})
+				if len(desc) < prstatusRegOffset+regsize {
+					return 0, fmt.Errorf("malformed NT_PRSTATUS note")
+				}
+
+				tid := int(binary.LittleEndian.Uint32(desc[prstatusPidOffset:]))
+				r := &coreRegs{}
+				if err := binary.Read(bytes.NewReader(desc[prstatusRegOffset:]), binary.LittleEndian, &r.regs); err != nil {
+					return 0, err
+				}
+
+				b.threads = append(b.threads, tid)
+				b.regs[tid] = r
+			case NT_PRPSINFO:
+				if len(desc) >= prpsinfoPidOffset+4 {
+					pid = int(binary.LittleEndian.Uint32(desc[prpsinfoPidOffset:]))
+				}
+			}
+		}
+	}
+
+	if pid == 0 && len(b.threads) > 0 {
+		pid = b.threads[0]
+	}
+
+	return pid, nil
+}
+
+func alignNote(n uint32) uint32 {
+	return (n + 3) &^ 3
+}
+
+func (b *coreBackend) ReadMemory(addr uintptr, size int) ([]byte, error) {
+	data := make([]byte, size)
+
+	for done := 0; done < size; {
+		cur := uint64(addr) + uint64(done)
+
+		seg := b.segmentFor(cur)
+		if seg == nil {
+			return nil, fmt.Errorf("could not read memory at %#v: address not in core file", cur)
+		}
+
+		n := size - done
+		if avail := seg.end - cur; uint64(n) > avail {
+			n = int(avail)
+		}
+
+		if _, err := seg.r.ReadAt(data[done:done+n], int64(cur-seg.start)); err != nil {
+			return nil, err
+		}
+		done += n
+	}
+
+	return data, nil
+}
+
+func (b *coreBackend) segmentFor(addr uint64) *coreSegment {
+	for i := range b.segments {
+		if b.segments[i].start <= addr && addr < b.segments[i].end {
+			return &b.segments[i]
+		}
+	}
+	return nil
+}
+
+func (b *coreBackend) WriteMemory(addr uintptr, data []byte) (int, error) {
+	return 0, ErrCoreFile
+}
+
+func (b *coreBackend) Registers(tid int) (Registers, error) {
+	r, ok := b.regs[tid]
+	if !ok {
+		return nil, fmt.Errorf("no thread %d in core file", tid)
+	}
+	return r, nil
+}
+
+func (b *coreBackend) SetRegisters(tid int, r Registers) error {
+	return ErrCoreFile
+}
+
+func (b *coreBackend) FpRegisters(tid int) ([]byte, error) {
+	return nil, ErrCoreFile
+}
+
+func (b *coreBackend) SetFpRegisters(tid int, data []byte) error {
+	return ErrCoreFile
+}
+
+func (b *coreBackend) Threads() ([]int, error) {
+	return b.threads, nil
+}
+
+func (b *coreBackend) DebugRegister(tid, reg int) (uint64, error) {
+	return 0, nil
+}
+
+func (b *coreBackend) SetDebugRegister(tid, reg int, value uint64) error {
+	return ErrCoreFile
+}
+
+func (b *coreBackend) Suspend() error {
+	return nil
+}
+
+func (b *coreBackend) Resume() error {
+	return ErrCoreFile
+}
+
+func (b *coreBackend) Halt() error {
+	return ErrCoreFile
+}
+
+func (b *coreBackend) SetSignal(tid int, sig syscall.Signal) error {
+	return ErrCoreFile
+}
+
+func (b *coreBackend) Detach() error {
+	return nil
+}
+
+func (b *coreBackend) Events() <-chan *trapEvent {
+	return b.chTrap
+}
@@ -0,0 +1,98 @@
+package proctl
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDumpAndOpenCore(t *testing.T) {
+	corepath := filepath.Join(os.TempDir(), "dlv-test-core")
+	defer os.Remove(corepath)
+
+	withTestProcess("../_fixtures/testprog", t, func(p *DebuggedProcess) {
+		fn := p.GoSymTable.LookupFunc("main.helloworld")
+
+		bp, err := p.Break(fn.Entry)
+		assertNoError(err, t, "Break()")
+
+		assertNoError(p.Dump(corepath), t, "Dump()")
+
+		exe, err := os.Readlink(fmt.Sprintf("/proc/%d/exe", p.Pid))
+		assertNoError(err, t, "Readlink()")
+
+		core, err := OpenCore(exe, corepath)
+		assertNoError(err, t, "OpenCore()")
+
+		data, err := core.readMemory(uintptr(bp.Addr), 1)
+		assertNoError(err, t, "readMemory()")
+
+		if !bytes.Equal(data, bp.OriginalData) {
+			t.Fatalf("Breakpoint leaked into core file: %#v, original: %#v", data, bp.OriginalData)
+		}
+
+		regs := getRegisters(p, t)
+		coreregs, err := core.registers(p.currentGoroutine.tid)
+		assertNoError(err, t, "registers()")
+
+		if coreregs.PC() != regs.PC() || coreregs.SP() != regs.SP() {
+			t.Fatalf("Registers differ: core %#v:%#v process %#v:%#v", coreregs.PC(), coreregs.SP(), regs.PC(), regs.SP())
+		}
+
+		if err := core.Continue(); err != ErrCoreFile {
+			t.Fatalf("Expected %s got %v", ErrCoreFile, err)
+		}
+
+		go func() {
+			time.Sleep(100 * time.Millisecond)
+			if err := p.Process.Kill(); err != nil {
+				t.Error(err)
+			}
+		}()
+
+		p.Clear(fn.Entry)
+		p.Continue()
+	})
+}
+
+func TestCoreCurrentGoroutine(t *testing.T) {
+	corepath := filepath.Join(os.TempDir(), "dlv-test-core-goroutine")
+	defer os.Remove(corepath)
+
+	withTestProcess("../_fixtures/testprog", t, func(p *DebuggedProcess) {
+		if p.currentGoroutine.id == 0 {
+			_, err := p.BreakByLocation("main.helloworld")
+			assertNoError(err, t, "BreakByLocation()")
+			assertNoError(p.Continue(), t, "Continue()")
+			return
+		}
+
+		assertNoError(p.Dump(corepath), t, "Dump()")
+
+		exe, err := os.Readlink(fmt.Sprintf("/proc/%d/exe", p.Pid))
+		assertNoError(err, t, "Readlink()")
+
+		core, err := OpenCore(exe, corepath)
+		assertNoError(err, t, "OpenCore()")
+
+		//what Listen does with the stop OpenCore queues
+		gid, err := core.getGid(p.currentGoroutine.tid)
+		assertNoError(err, t, "getGid()")
+		if gid != p.currentGoroutine.id {
+			t.Fatalf("Expected goroutine %d in the core, got %d", p.currentGoroutine.id, gid)
+		}
+
+		go func() {
+			time.Sleep(100 * time.Millisecond)
+			if err := p.Process.Kill(); err != nil {
+				t.Error(err)
+			}
+		}()
+
+		p.ClearByLocation("main.helloworld")
+		p.Continue()
+	})
+}
@@ -0,0 +1,148 @@
+package proctl
+
+import (
+	"debug/gosym"
+	"fmt"
+
+	"golang.org/x/arch/x86/x86asm"
+)
+
+const maxInstructionLength = 15
+
+// A decoded instruction of the program.
+type AsmInstruction struct {
+	PC       uint64
+	Bytes    []byte
+	Text     string //in Go assembler syntax, "?" when it does not decode
+	File     string
+	Line     int
+	Function string
+
+	AtPC       bool //the selected goroutine or frame is stopped here
+	Breakpoint bool
+}
+
+// Decodes the instructions from start up to end. Breakpoints are read
+// as the instructions they replaced, the targets of calls and jumps are
+// given as functions.
+func (dbp *DebuggedProcess) Disassemble(start, end uint64) ([]AsmInstruction, error) {
+	if end <= start {
+		return nil, fmt.Errorf("invalid range %#x-%#x", start, end)
+	}
+	mem, err := dbp.readOriginalMemory(start, int(end-start))
+	if err != nil {
+		//tell which part of the range is not mapped
+		if err := dbp.checkReadable(start, int(end-start)); err != nil {
+			return nil, err
+		}
+		return nil, err
+	}
+
+	var curpc uint64
+	if dbp.currentGoroutine != nil {
+		if curpc, err = dbp.CurrentPCForDisplay(); err != nil {
+			return nil, err
+		}
+	}
+
+	var insts []AsmInstruction
+	for pc := start; pc < end; {
+		code := mem[pc-start:]
+
+		inst := AsmInstruction{PC: pc, AtPC: pc == curpc}
+		if x, err := x86asm.Decode(code, 64); err == nil {
+			inst.Bytes = code[:x.Len]
+			inst.Text = x86asm.GoSyntax(x, pc, dbp.symbolAt) + dbp.branchTarget(x, pc)
+		} else {
+			inst.Bytes = code[:1]
+			inst.Text = "?"
+		}
+
+		if bp, ok := dbp.Breakpoints[pc]; ok && !bp.isTemp() {
+			inst.Breakpoint = true
+		}
+
+		var fn *gosym.Func
+		inst.File, inst.Line, fn = dbp.GoSymTable.PCToLine(pc)
+		if fn != nil {
+			inst.Function = fn.Name
+		}
+
+		insts = append(insts, inst)
+		pc += uint64(len(inst.Bytes))
+	}
+
+	return insts, nil
+}
+
+// Returns the function addr is in and its entry, as the decoder looks
+// up symbols.
+func (dbp *DebuggedProcess) symbolAt(addr uint64) (string, uint64) {
+	fn := dbp.GoSymTable.PCToFunc(addr)
+	if fn == nil {
+		return "", 0
+	}
+	return fn.Name, fn.Entry
+}
+
+// Names the target of a relative call or jump into the middle of a
+// function, e.g. a loop, the decoder only names function entries.
+func (dbp *DebuggedProcess) branchTarget(x x86asm.Inst, pc uint64) string {
+	for _, a := range x.Args {
+		rel, ok := a.(x86asm.Rel)
+		if !ok {
+			continue
+		}
+
+		addr := pc + uint64(x.Len) + uint64(rel)
+		if name, entry := dbp.symbolAt(addr); name != "" && addr != entry {
+			return fmt.Sprintf(" <%s+%#x>", name, addr-entry)
+		}
+	}
+
+	return ""
+}
+
+// Reports whether a single step of the instruction at pc, with the
+// stack pointer at sp, ended somewhere the instruction does not lead
+// to: the kernel ran a signal handler first.
+func (dbp *DebuggedProcess) stepDiverted(pc, sp, newpc, newsp uint64) bool {
+	mem, err := dbp.readOriginalMemory(pc, maxInstructionLength)
+	if err != nil {
+		return false
+	}
+	x, err := x86asm.Decode(mem, 64)
+	if err != nil {
+		return false
+	}
+	next := pc + uint64(x.Len)
+
+	var target uint64
+	for _, a := range x.Args {
+		if rel, ok := a.(x86asm.Rel); ok {
+			target = next + uint64(rel)
+		}
+	}
+
+	switch x.Op {
+	case x86asm.CALL:
+		if target != 0 && newpc != target {
+			return true
+		}
+		ret, err := dbp.readUint64(newsp)
+		return newsp != sp-8 || err != nil || ret != next
+	case x86asm.RET:
+		return newsp != sp+8
+	case x86asm.LCALL, x86asm.LJMP, x86asm.LRET:
+		return false
+	case x86asm.JMP:
+		return target != 0 && newpc != target
+	}
+
+	if target != 0 {
+		//conditional jumps and loops
+		return newpc != next && newpc != target
+	}
+	//repeated string instructions stay at pc until done
+	return newpc != next && newpc != pc
+}
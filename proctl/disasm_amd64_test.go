@@ -0,0 +1,36 @@
+package proctl
+
+import "testing"
+
+func TestFakeDisassemble(t *testing.T) {
+	withFakeProcess(t, func(p *DebuggedProcess, fb *fakeBackend) {
+		fb.WriteMemory(fakeFuncEntry, []byte{
+			0x55,                         //PUSHQ BP
+			0xe8, 0xfa, 0xff, 0xff, 0xff, //CALL main.fake
+			0xeb, 0xf9, //JMP back to the call
+			0xc3, //RET
+		})
+
+		_, err := p.Break(fakeFuncEntry + 1)
+		assertNoError(err, t, "Break()")
+
+		insts, err := p.Disassemble(fakeFuncEntry, fakeFuncEntry+9)
+		assertNoError(err, t, "Disassemble()")
+
+		expected := []string{"PUSHQ BP", "CALL main.fake(SB)", "JMP 0x400001 <main.fake+0x1>", "RET"}
+		if len(insts) != len(expected) {
+			t.Fatalf("Expected %d instructions, got %#v", len(expected), insts)
+		}
+		for i, inst := range insts {
+			if inst.Text != expected[i] {
+				t.Fatalf("Expected %q at %#x, got %q", expected[i], inst.PC, inst.Text)
+			}
+			if inst.Function != "main.fake" {
+				t.Fatalf("Expected main.fake at %#x, got %q", inst.PC, inst.Function)
+			}
+			if inst.Breakpoint != (inst.PC == fakeFuncEntry+1) {
+				t.Fatalf("Breakpoint marked wrong at %#x", inst.PC)
+			}
+		}
+	})
+}
@@ -0,0 +1,300 @@
+package proctl
+
+import (
+	"bufio"
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+const (
+	prstatusSize          = 336 // sizeof(struct elf_prstatus)
+	prstatusCursigOffset  = 12  // elf_prstatus.pr_cursig
+	prstatusFpvalidOffset = 328 // elf_prstatus.pr_fpvalid
+	prpsinfoSize          = 136 // sizeof(struct elf_prpsinfo)
+	prpsinfoFnameOffset   = 40  // elf_prpsinfo.pr_fname
+	prpsinfoArgsOffset    = 56  // elf_prpsinfo.pr_psargs
+
+	pageSize = 0x1000
+)
+
+// A readable mapping from /proc/<pid>/maps.
+type memoryMapping struct {
+	start, end uint64
+	flags      elf.ProgFlag
+}
+
+// Writes an ELF core file of the stopped process to path. Breakpoints
+// are written with their original instruction bytes.
+func (dbp *DebuggedProcess) Dump(path string) error {
+	if dbp.core {
+		return ErrCoreFile
+	}
+
+	mappings, err := dbp.readMappings()
+	if err != nil {
+		return err
+	}
+
+	notes, err := dbp.coreNotes()
+	if err != nil {
+		return err
+	}
+
+	mem, err := os.Open(fmt.Sprintf("/proc/%d/mem", dbp.Pid))
+	if err != nil {
+		return err
+	}
+	defer mem.Close()
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w := bufio.NewWriter(f)
+
+	phnum := len(mappings) + 1
+	hdr := elf.Header64{
+		Type:      uint16(elf.ET_CORE),
+		Machine:   uint16(elf.EM_X86_64),
+		Version:   uint32(elf.EV_CURRENT),
+		Phoff:     uint64(binary.Size(elf.Header64{})),
+		Ehsize:    uint16(binary.Size(elf.Header64{})),
+		Phentsize: uint16(binary.Size(elf.Prog64{})),
+		Phnum:     uint16(phnum),
+	}
+	copy(hdr.Ident[:], elf.ELFMAG)
+	hdr.Ident[elf.EI_CLASS] = byte(elf.ELFCLASS64)
+	hdr.Ident[elf.EI_DATA] = byte(elf.ELFDATA2LSB)
+	hdr.Ident[elf.EI_VERSION] = byte(elf.EV_CURRENT)
+
+	noteoff := hdr.Phoff + uint64(phnum)*uint64(hdr.Phentsize)
+	off := alignPage(noteoff + uint64(len(notes)))
+
+	progs := make([]elf.Prog64, 0, phnum)
+	progs = append(progs, elf.Prog64{
+		Type:   uint32(elf.PT_NOTE),
+		Off:    noteoff,
+		Filesz: uint64(len(notes)),
+	})
+	for _, m := range mappings {
+		progs = append(progs, elf.Prog64{
+			Type:   uint32(elf.PT_LOAD),
+			Flags:  uint32(m.flags),
+			Off:    off,
+			Vaddr:  m.start,
+			Filesz: m.end - m.start,
+			Memsz:  m.end - m.start,
+			Align:  pageSize,
+		})
+		off += m.end - m.start
+	}
+
+	if err := binary.Write(w, binary.LittleEndian, &hdr); err != nil {
+		return err
+	}
+	if err := binary.Write(w, binary.LittleEndian, progs); err != nil {
+		return err
+	}
+	if _, err := w.Write(notes); err != nil {
+		return err
+	}
+
+	written := noteoff + uint64(len(notes))
+	if _, err := w.Write(make([]byte, alignPage(written)-written)); err != nil {
+		return err
+	}
+
+	for _, m := range mappings {
+		data := make([]byte, m.end-m.start)
+		if _, err := mem.ReadAt(data, int64(m.start)); err != nil {
+			//keep the segment zeroed so the offsets of the following ones hold
+			log.Printf("could not read memory at %#v: %s", m.start, err)
+		}
+
+		for addr, bp := range dbp.Breakpoints {
+			if m.start <= addr && addr < m.end {
+				copy(data[addr-m.start:], bp.OriginalData)
+			}
+		}
+
+		if _, err := w.Write(data); err != nil {
+			return err
+		}
+	}
+
+	return w.Flush()
+}
+
+// Returns the readable mappings of the process, leaving out the
+// ones the kernel does not let us read.
+func (dbp *DebuggedProcess) readMappings() ([]memoryMapping, error) {
+	f, err := os.Open(fmt.Sprintf("/proc/%d/maps", dbp.Pid))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var mappings []memoryMapping
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 5 {
+			continue
+		}
+
+		perms := fields[1]
+		if perms[0] != 'r' {
+			continue
+		}
+		if len(fields) >= 6 && (fields[5] == "[vvar]" || fields[5] == "[vsyscall]") {
+			continue
+		}
+
+		bounds := strings.Split(fields[0], "-")
+		start, err := strconv.ParseUint(bounds[0], 16, 64)
+		if err != nil {
+			return nil, err
+		}
+		end, err := strconv.ParseUint(bounds[1], 16, 64)
+		if err != nil {
+			return nil, err
+		}
+
+		m := memoryMapping{start: start, end: end, flags: elf.PF_R}
+		if perms[1] == 'w' {
+			m.flags |= elf.PF_W
+		}
+		if perms[2] == 'x' {
+			m.flags |= elf.PF_X
+		}
+		mappings = append(mappings, m)
+	}
+
+	return mappings, scanner.Err()
+}
+
+// Returns an error naming the first address of the size bytes at addr
+// that is not in a readable mapping, reading it would fail halfway.
+func (dbp *DebuggedProcess) checkReadable(addr uint64, size int) error {
+	if dbp.core {
+		//the core backend reports addresses missing from the file
+		return nil
+	}
+
+	mappings, err := dbp.readMappings()
+	if err != nil {
+		return err
+	}
+
+	end := addr + uint64(size)
+	for cur := addr; cur < end; {
+		var (
+			found *memoryMapping
+			next  uint64
+		)
+		for i := range mappings {
+			m := &mappings[i]
+			if m.start <= cur && cur < m.end {
+				found = m
+				break
+			}
+			if m.start > cur && (next == 0 || m.start < next) {
+				next = m.start
+			}
+		}
+
+		if found == nil {
+			if next != 0 {
+				return fmt.Errorf("can not read %d bytes at %#x: %#x is not in a readable mapping of the process, the next one starts at %#x", size, addr, cur, next)
+			}
+			return fmt.Errorf("can not read %d bytes at %#x: %#x is not in a readable mapping of the process", size, addr, cur)
+		}
+		cur = found.end
+	}
+
+	return nil
+}
+
+// Builds the note segment: one NT_PRPSINFO for the process and one
+// NT_PRSTATUS per thread, starting with the current one.
+func (dbp *DebuggedProcess) coreNotes() ([]byte, error) {
+	var buf bytes.Buffer
+
+	psinfo := make([]byte, prpsinfoSize)
+	binary.LittleEndian.PutUint32(psinfo[prpsinfoPidOffset:], uint32(dbp.Pid))
+	if exe, err := os.Readlink(fmt.Sprintf("/proc/%d/exe", dbp.Pid)); err == nil {
+		copy(psinfo[prpsinfoFnameOffset:prpsinfoArgsOffset-1], filepath.Base(exe))
+	}
+	if cmdline, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/cmdline", dbp.Pid)); err == nil {
+		copy(psinfo[prpsinfoArgsOffset:prpsinfoSize-1], bytes.Replace(cmdline, []byte{0}, []byte{' '}, -1))
+	}
+	writeNote(&buf, NT_PRPSINFO, psinfo)
+
+	ths, err := dbp.getThreads()
+	if err != nil {
+		return nil, err
+	}
+
+	if dbp.currentGoroutine != nil {
+		for i, tid := range ths {
+			if tid == dbp.currentGoroutine.tid {
+				ths[0], ths[i] = ths[i], ths[0]
+				break
+			}
+		}
+	}
+
+	for _, tid := range ths {
+		regs, err := dbp.registers(tid)
+		if err != nil {
+			return nil, err
+		}
+
+		r, ok := regs.(*Regs)
+		if !ok {
+			return nil, fmt.Errorf("unexpected registers for thread %d", tid)
+		}
+
+		status := make([]byte, prstatusSize)
+		binary.LittleEndian.PutUint16(status[prstatusCursigOffset:], uint16(syscall.SIGTRAP))
+		binary.LittleEndian.PutUint32(status[prstatusPidOffset:], uint32(tid))
+
+		var regbuf bytes.Buffer
+		if err := binary.Write(&regbuf, binary.LittleEndian, (*syscall.PtraceRegs)(r)); err != nil {
+			return nil, err
+		}
+		copy(status[prstatusRegOffset:prstatusFpvalidOffset], regbuf.Bytes())
+
+		writeNote(&buf, NT_PRSTATUS, status)
+	}
+
+	return buf.Bytes(), nil
+}
+
+func writeNote(buf *bytes.Buffer, typ uint32, desc []byte) {
+	name := []byte("CORE\x00")
+
+	binary.Write(buf, binary.LittleEndian, uint32(len(name)))
+	binary.Write(buf, binary.LittleEndian, uint32(len(desc)))
+	binary.Write(buf, binary.LittleEndian, typ)
+	buf.Write(name)
+	buf.Write(make([]byte, alignNote(uint32(len(name)))-uint32(len(name))))
+	buf.Write(desc)
+	buf.Write(make([]byte, alignNote(uint32(len(desc)))-uint32(len(desc))))
+}
+
+func alignPage(n uint64) uint64 {
+	return (n + pageSize - 1) &^ (pageSize - 1)
+}
@@ -0,0 +1,712 @@
+package proctl
+
+import (
+	"bytes"
+	"debug/dwarf"
+	"encoding/binary"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/printer"
+	"go/token"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Value of a pointer variable, only comparable against nil or
+// another pointer.
+type pointer uint64
+
+// Parses the expression of a breakpoint condition.
+func parseCondition(expr string) (ast.Expr, error) {
+	t, err := parser.ParseExpr(expr)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse condition %q: %s", expr, err)
+	}
+	return t, nil
+}
+
+// Evaluates a boolean expression in the current frame of g.
+func (g *Goroutine) evalCondition(expr ast.Expr) (bool, error) {
+	v, err := g.evalExpr(expr)
+	if err != nil {
+		return false, err
+	}
+
+	b, ok := v.(bool)
+	if !ok {
+		return false, fmt.Errorf("condition is not a boolean: %v", v)
+	}
+	return b, nil
+}
+
+// Evaluates expr to an int64, uint64, float64, string, bool or pointer.
+func (g *Goroutine) evalExpr(expr ast.Expr) (interface{}, error) {
+	switch e := expr.(type) {
+	case *ast.ParenExpr:
+		return g.evalExpr(e.X)
+	case *ast.BasicLit:
+		return evalLiteral(e)
+	case *ast.Ident:
+		switch e.Name {
+		case "true":
+			return true, nil
+		case "false":
+			return false, nil
+		case "nil":
+			return pointer(0), nil
+		}
+		return g.evalVariable(e.Name)
+	case *ast.SelectorExpr, *ast.IndexExpr, *ast.StarExpr:
+		addr, typ, _, err := g.lvalue(e)
+		if err != nil {
+			return nil, err
+		}
+		return g.readValue(addr, typ, exprString(e))
+	case *ast.UnaryExpr:
+		x, err := g.evalExpr(e.X)
+		if err != nil {
+			return nil, err
+		}
+		return evalUnary(e.Op, x)
+	case *ast.BinaryExpr:
+		x, err := g.evalExpr(e.X)
+		if err != nil {
+			return nil, err
+		}
+
+		//short circuit like Go does, the right side may not be valid
+		if b, ok := x.(bool); ok && (e.Op == token.LAND && !b || e.Op == token.LOR && b) {
+			return b, nil
+		}
+
+		y, err := g.evalExpr(e.Y)
+		if err != nil {
+			return nil, err
+		}
+		return evalBinary(e.Op, x, y)
+	}
+
+	return nil, fmt.Errorf("unsupported expression %T", expr)
+}
+
+func selectorName(e *ast.SelectorExpr) (string, error) {
+	switch x := e.X.(type) {
+	case *ast.Ident:
+		return x.Name + "." + e.Sel.Name, nil
+	case *ast.SelectorExpr:
+		name, err := selectorName(x)
+		if err != nil {
+			return "", err
+		}
+		return name + "." + e.Sel.Name, nil
+	}
+
+	return "", fmt.Errorf("unsupported expression %T", e.X)
+}
+
+func evalLiteral(lit *ast.BasicLit) (interface{}, error) {
+	switch lit.Kind {
+	case token.INT:
+		return strconv.ParseInt(lit.Value, 0, 64)
+	case token.FLOAT:
+		return strconv.ParseFloat(lit.Value, 64)
+	case token.CHAR:
+		r, _, _, err := strconv.UnquoteChar(lit.Value[1:len(lit.Value)-1], '\'')
+		return int64(r), err
+	case token.STRING:
+		return strconv.Unquote(lit.Value)
+	}
+
+	return nil, fmt.Errorf("unsupported literal %s", lit.Value)
+}
+
+// Reads the value of the named variable or struct member.
+func (g *Goroutine) evalVariable(name string) (interface{}, error) {
+	addr, typ, _, err := g.variableAddress(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return g.readValue(addr, typ, name)
+}
+
+// Reads the value of type typ at addr for an expression, name is what
+// errors call it.
+func (g *Goroutine) readValue(addr uint64, typ dwarf.Type, name string) (interface{}, error) {
+	typ = resolveTypedef(typ)
+	switch t := typ.(type) {
+	case *dwarf.BoolType:
+		val, err := g.dbp.readMemory(uintptr(addr), 1)
+		if err != nil {
+			return nil, err
+		}
+		return val[0] != 0, nil
+	case *dwarf.IntType, *dwarf.UintType, *dwarf.FloatType, *dwarf.PtrType:
+		val, err := g.dbp.readMemory(uintptr(addr), int(t.Size()))
+		if err != nil {
+			return nil, err
+		}
+
+		var n uint64
+		switch len(val) {
+		case 1:
+			n = uint64(val[0])
+		case 2:
+			n = uint64(binary.LittleEndian.Uint16(val))
+		case 4:
+			n = uint64(binary.LittleEndian.Uint32(val))
+		case 8:
+			n = binary.LittleEndian.Uint64(val)
+		default:
+			return nil, fmt.Errorf("unsupported size %d for %s", len(val), name)
+		}
+
+		switch t.(type) {
+		case *dwarf.IntType:
+			//sign extend
+			shift := uint(64 - 8*len(val))
+			return int64(n<<shift) >> shift, nil
+		case *dwarf.UintType:
+			return n, nil
+		case *dwarf.FloatType:
+			if len(val) == 4 {
+				return float64(math.Float32frombits(uint32(n))), nil
+			}
+			return math.Float64frombits(n), nil
+		default:
+			return pointer(n), nil
+		}
+	case *dwarf.StructType:
+		if t.StructName == "string" {
+			return g.readString(uintptr(addr))
+		}
+	}
+
+	return nil, fmt.Errorf("can not use %s of type %s in an expression", name, typ)
+}
+
+// Returns the underlying type of a user defined type.
+func resolveTypedef(typ dwarf.Type) dwarf.Type {
+	for {
+		tt, ok := typ.(*dwarf.TypedefType)
+		if !ok {
+			return typ
+		}
+		typ = tt.Type
+	}
+}
+
+// Encodes v, as returned by evalExpr, in the memory representation of
+// typ. Values of another kind or out of the range of typ are rejected.
+func encodeValue(v interface{}, typ dwarf.Type) ([]byte, error) {
+	buf := make([]byte, 8)
+	size := resolveTypedef(typ).Size()
+
+	switch resolveTypedef(typ).(type) {
+	case *dwarf.BoolType:
+		if b, ok := v.(bool); ok {
+			if b {
+				buf[0] = 1
+			}
+			return buf[:1], nil
+		}
+	case *dwarf.IntType:
+		var n int64
+		switch x := v.(type) {
+		case int64:
+			n = x
+		case uint64:
+			if x > math.MaxInt64 {
+				return nil, fmt.Errorf("%d overflows %s", x, typ)
+			}
+			n = int64(x)
+		default:
+			return nil, mismatchError(v, typ)
+		}
+		shift := uint(64 - 8*size)
+		if n<<shift>>shift != n {
+			return nil, fmt.Errorf("%d overflows %s", n, typ)
+		}
+		binary.LittleEndian.PutUint64(buf, uint64(n))
+		return buf[:size], nil
+	case *dwarf.UintType:
+		var n uint64
+		switch x := v.(type) {
+		case uint64:
+			n = x
+		case int64:
+			if x < 0 {
+				return nil, fmt.Errorf("%d overflows %s", x, typ)
+			}
+			n = uint64(x)
+		default:
+			return nil, mismatchError(v, typ)
+		}
+		if size < 8 && n>>uint(8*size) != 0 {
+			return nil, fmt.Errorf("%d overflows %s", n, typ)
+		}
+		binary.LittleEndian.PutUint64(buf, n)
+		return buf[:size], nil
+	case *dwarf.FloatType:
+		var f float64
+		switch x := v.(type) {
+		case float64:
+			f = x
+		case int64:
+			f = float64(x)
+		case uint64:
+			f = float64(x)
+		default:
+			return nil, mismatchError(v, typ)
+		}
+		if size == 4 {
+			binary.LittleEndian.PutUint32(buf, math.Float32bits(float32(f)))
+			return buf[:4], nil
+		}
+		binary.LittleEndian.PutUint64(buf, math.Float64bits(f))
+		return buf, nil
+	case *dwarf.PtrType:
+		if p, ok := v.(pointer); ok {
+			binary.LittleEndian.PutUint64(buf, uint64(p))
+			return buf, nil
+		}
+	case *dwarf.StructType:
+		if _, ok := v.(string); ok {
+			//the bytes of a constant have no place in the program
+			return nil, fmt.Errorf("can not use string constant %q as %s, use a string variable", v, typ)
+		}
+	}
+
+	return nil, mismatchError(v, typ)
+}
+
+func mismatchError(v interface{}, typ dwarf.Type) error {
+	kind := "pointer"
+	switch v.(type) {
+	case int64, uint64:
+		kind = "integer"
+	case float64:
+		kind = "float"
+	case bool:
+		kind = "bool"
+	case string:
+		kind = "string"
+	}
+	return fmt.Errorf("can not use %s %v as %s", kind, v, typ)
+}
+
+func evalUnary(op token.Token, x interface{}) (interface{}, error) {
+	switch op {
+	case token.NOT:
+		if b, ok := x.(bool); ok {
+			return !b, nil
+		}
+	case token.SUB:
+		switch v := x.(type) {
+		case int64:
+			return -v, nil
+		case float64:
+			return -v, nil
+		}
+	case token.ADD:
+		switch x.(type) {
+		case int64, uint64, float64:
+			return x, nil
+		}
+	}
+
+	return nil, fmt.Errorf("invalid operation: %s%v", op, x)
+}
+
+// Converts x and y to the same type. Untyped literals are int64 or
+// float64, so mixed signed and unsigned operands compare as integers
+// and any float makes the other operand a float. A negative int64 never
+// gets here with a uint64, see cmpSigns.
+func matchTypes(x, y interface{}) (interface{}, interface{}) {
+	switch a := x.(type) {
+	case int64:
+		switch b := y.(type) {
+		case uint64:
+			return uint64(a), b
+		case float64:
+			return float64(a), b
+		}
+	case uint64:
+		switch b := y.(type) {
+		case int64:
+			return a, uint64(b)
+		case float64:
+			return float64(a), b
+		}
+	case float64:
+		switch b := y.(type) {
+		case int64:
+			return a, float64(b)
+		case uint64:
+			return a, float64(b)
+		}
+	}
+
+	return x, y
+}
+
+// Compares a negative int64 with a uint64, which no conversion
+// between the two preserves. Reports false for other operands.
+func cmpSigns(x, y interface{}) (int, bool) {
+	switch a := x.(type) {
+	case int64:
+		if _, ok := y.(uint64); ok && a < 0 {
+			return -1, true
+		}
+	case uint64:
+		if b, ok := y.(int64); ok && b < 0 {
+			return 1, true
+		}
+	}
+	return 0, false
+}
+
+func evalBinary(op token.Token, x, y interface{}) (interface{}, error) {
+	if c, ok := cmpSigns(x, y); ok {
+		switch op {
+		case token.EQL, token.NEQ, token.LSS, token.LEQ, token.GTR, token.GEQ:
+			return compare(op, c)
+		}
+		return nil, fmt.Errorf("invalid operation: %v %s %v, negative value with an unsigned operand", x, op, y)
+	}
+
+	x, y = matchTypes(x, y)
+
+	invalid := fmt.Errorf("invalid operation: %v %s %v", x, op, y)
+
+	switch a := x.(type) {
+	case bool:
+		b, ok := y.(bool)
+		if !ok {
+			return nil, invalid
+		}
+		switch op {
+		case token.LAND:
+			return a && b, nil
+		case token.LOR:
+			return a || b, nil
+		case token.EQL:
+			return a == b, nil
+		case token.NEQ:
+			return a != b, nil
+		}
+	case pointer:
+		b, ok := y.(pointer)
+		if !ok {
+			return nil, invalid
+		}
+		switch op {
+		case token.EQL:
+			return a == b, nil
+		case token.NEQ:
+			return a != b, nil
+		}
+	case string:
+		b, ok := y.(string)
+		if !ok {
+			return nil, invalid
+		}
+		switch op {
+		case token.ADD:
+			return a + b, nil
+		}
+		return compare(op, cmpString(a, b))
+	case int64:
+		b, ok := y.(int64)
+		if !ok {
+			return nil, invalid
+		}
+		switch op {
+		case token.ADD:
+			return a + b, nil
+		case token.SUB:
+			return a - b, nil
+		case token.MUL:
+			return a * b, nil
+		case token.QUO, token.REM:
+			if b == 0 {
+				return nil, fmt.Errorf("division by zero")
+			}
+			if op == token.QUO {
+				return a / b, nil
+			}
+			return a % b, nil
+		}
+		return compare(op, cmpInt64(a, b))
+	case uint64:
+		b, ok := y.(uint64)
+		if !ok {
+			return nil, invalid
+		}
+		switch op {
+		case token.ADD:
+			return a + b, nil
+		case token.SUB:
+			return a - b, nil
+		case token.MUL:
+			return a * b, nil
+		case token.QUO, token.REM:
+			if b == 0 {
+				return nil, fmt.Errorf("division by zero")
+			}
+			if op == token.QUO {
+				return a / b, nil
+			}
+			return a % b, nil
+		}
+		return compare(op, cmpUint64(a, b))
+	case float64:
+		b, ok := y.(float64)
+		if !ok {
+			return nil, invalid
+		}
+		switch op {
+		case token.ADD:
+			return a + b, nil
+		case token.SUB:
+			return a - b, nil
+		case token.MUL:
+			return a * b, nil
+		case token.QUO:
+			return a / b, nil
+		}
+		switch {
+		case a < b:
+			return compare(op, -1)
+		case a > b:
+			return compare(op, 1)
+		}
+		return compare(op, 0)
+	}
+
+	return nil, invalid
+}
+
+func cmpInt64(a, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func cmpString(a, b string) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func cmpUint64(a, b uint64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// Applies the comparison op to the result c of comparing two values.
+func compare(op token.Token, c int) (interface{}, error) {
+	switch op {
+	case token.EQL:
+		return c == 0, nil
+	case token.NEQ:
+		return c != 0, nil
+	case token.LSS:
+		return c < 0, nil
+	case token.LEQ:
+		return c <= 0, nil
+	case token.GTR:
+		return c > 0, nil
+	case token.GEQ:
+		return c >= 0, nil
+	}
+
+	return nil, fmt.Errorf("invalid operator %s", op)
+}
+
+// Returns the address and type of the variable, struct field, array or
+// slice element or pointer target expr denotes, and whether it lives
+// in the current stack frame.
+func (g *Goroutine) lvalue(expr ast.Expr) (uint64, dwarf.Type, bool, error) {
+	switch e := expr.(type) {
+	case *ast.ParenExpr:
+		return g.lvalue(e.X)
+	case *ast.Ident:
+		switch e.Name {
+		case "true", "false", "nil":
+			return 0, nil, false, fmt.Errorf("%s is not a variable", e.Name)
+		}
+		return g.variableAddress(e.Name)
+	case *ast.SelectorExpr:
+		//variables, members of variables and package variables
+		if name, err := selectorName(e); err == nil {
+			if addr, typ, inFrame, err := g.variableAddress(name); err == nil {
+				return addr, typ, inFrame, nil
+			}
+		}
+
+		addr, typ, inFrame, err := g.lvalue(e.X)
+		if err != nil {
+			return 0, nil, false, err
+		}
+		return g.fieldAddress(addr, typ, inFrame, e.Sel.Name)
+	case *ast.StarExpr:
+		addr, typ, _, err := g.lvalue(e.X)
+		if err != nil {
+			return 0, nil, false, err
+		}
+		pt, ok := resolveTypedef(typ).(*dwarf.PtrType)
+		if !ok {
+			return 0, nil, false, fmt.Errorf("can not dereference %s of type %s", exprString(e.X), typ)
+		}
+		if addr, err = g.derefPointer(addr); err != nil {
+			return 0, nil, false, err
+		}
+		return addr, pt.Type, false, nil
+	case *ast.IndexExpr:
+		addr, typ, inFrame, err := g.lvalue(e.X)
+		if err != nil {
+			return 0, nil, false, err
+		}
+		v, err := g.evalExpr(e.Index)
+		if err != nil {
+			return 0, nil, false, err
+		}
+		var i uint64
+		switch n := v.(type) {
+		case int64:
+			if n < 0 {
+				return 0, nil, false, fmt.Errorf("invalid index %d", n)
+			}
+			i = uint64(n)
+		case uint64:
+			i = n
+		default:
+			return 0, nil, false, fmt.Errorf("index %s is not an integer", exprString(e.Index))
+		}
+		return g.elementAddress(addr, typ, inFrame, i)
+	}
+
+	return 0, nil, false, fmt.Errorf("unsupported expression %T", expr)
+}
+
+// Returns the address and type of the named field of the struct, or
+// of the struct a pointer points to, at addr.
+func (g *Goroutine) fieldAddress(addr uint64, typ dwarf.Type, inFrame bool, name string) (uint64, dwarf.Type, bool, error) {
+	if pt, ok := resolveTypedef(typ).(*dwarf.PtrType); ok {
+		var err error
+		if addr, err = g.derefPointer(addr); err != nil {
+			return 0, nil, false, err
+		}
+		typ, inFrame = pt.Type, false
+	}
+
+	st, ok := resolveTypedef(typ).(*dwarf.StructType)
+	if !ok {
+		return 0, nil, false, fmt.Errorf("%s is not a struct", typ)
+	}
+	for _, f := range st.Field {
+		if f.Name == name {
+			return addr + uint64(f.ByteOffset), f.Type, inFrame, nil
+		}
+	}
+
+	return 0, nil, false, fmt.Errorf("%s has no field %s", typ, name)
+}
+
+// Returns the address and type of element i of the array or slice at
+// addr.
+func (g *Goroutine) elementAddress(addr uint64, typ dwarf.Type, inFrame bool, i uint64) (uint64, dwarf.Type, bool, error) {
+	switch t := resolveTypedef(typ).(type) {
+	case *dwarf.ArrayType:
+		if i >= uint64(t.Count) {
+			return 0, nil, false, fmt.Errorf("index %d out of range for %s", i, typ)
+		}
+		return addr + i*uint64(t.Type.Size()), t.Type, inFrame, nil
+	case *dwarf.StructType:
+		if !strings.HasPrefix(t.StructName, "[]") || len(t.Field) < 2 {
+			break
+		}
+
+		//slices are {array, len, cap}
+		array, length := t.Field[0], t.Field[1]
+		pt, ok := array.Type.(*dwarf.PtrType)
+		if !ok {
+			break
+		}
+		data, err := g.dbp.readMemory(uintptr(addr), int(t.Size()))
+		if err != nil {
+			return 0, nil, false, err
+		}
+		base := binary.LittleEndian.Uint64(data[array.ByteOffset:])
+		n := binary.LittleEndian.Uint64(data[length.ByteOffset:])
+		if i >= n {
+			return 0, nil, false, fmt.Errorf("index %d out of range for %s of length %d", i, typ, n)
+		}
+		return base + i*uint64(pt.Type.Size()), pt.Type, false, nil
+	}
+
+	return 0, nil, false, fmt.Errorf("can not index %s", typ)
+}
+
+func (g *Goroutine) derefPointer(addr uint64) (uint64, error) {
+	ptr, err := g.dbp.readUint64(addr)
+	if err != nil {
+		return 0, err
+	}
+	if ptr == 0 {
+		return 0, fmt.Errorf("nil pointer dereference")
+	}
+	return ptr, nil
+}
+
+// Encodes the value of expr as a value of type typ. Variables of that
+// type are copied as they are, which is how strings and structs are
+// assigned. Addresses of variables on the stack are only taken when
+// stackOK is set, stacks move.
+func (g *Goroutine) encodeExpr(expr ast.Expr, typ dwarf.Type, stackOK bool) ([]byte, error) {
+	if u, ok := expr.(*ast.UnaryExpr); ok && u.Op == token.AND {
+		addr, vt, inFrame, err := g.lvalue(u.X)
+		if err != nil {
+			return nil, err
+		}
+		if inFrame && !stackOK {
+			return nil, fmt.Errorf("%s lives on the stack of goroutine %d, its address can not be used here", exprString(u.X), g.id)
+		}
+		if "*"+vt.String() != typ.String() {
+			return nil, fmt.Errorf("can not use %s (type *%s) as %s", exprString(expr), vt, typ)
+		}
+		return addressBytes(addr), nil
+	}
+
+	if addr, vt, _, err := g.lvalue(expr); err == nil && vt.String() == typ.String() {
+		return g.dbp.readMemory(uintptr(addr), int(typ.Size()))
+	}
+
+	v, err := g.evalExpr(expr)
+	if err != nil {
+		return nil, err
+	}
+	return encodeValue(v, typ)
+}
+
+func exprString(expr ast.Expr) string {
+	var buf bytes.Buffer
+	printer.Fprint(&buf, token.NewFileSet(), expr)
+	return buf.String()
+}
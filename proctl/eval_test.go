@@ -0,0 +1,118 @@
+package proctl
+
+import (
+	"bytes"
+	"debug/dwarf"
+	"go/token"
+	"math"
+	"testing"
+)
+
+func TestEvalLiteralExpressions(t *testing.T) {
+	testcases := []struct {
+		expr  string
+		value interface{}
+	}{
+		{"1 + 2*3", int64(7)},
+		{"7 % 4 == 3", true},
+		{"-1 < 0 && !false", true},
+		{"1.5 > 1", true},
+		{`"ab" + "c" == "abc"`, true},
+		{"'a' == 97", true},
+		{"nil == nil", true},
+		{"false || 2 >= 3", false},
+	}
+
+	g := &Goroutine{}
+	for _, tc := range testcases {
+		expr, err := parseCondition(tc.expr)
+		assertNoError(err, t, "parseCondition()")
+
+		val, err := g.evalExpr(expr)
+		assertNoError(err, t, "evalExpr()")
+
+		if val != tc.value {
+			t.Fatalf("%s: expected %v got %v", tc.expr, tc.value, val)
+		}
+	}
+
+	for _, expr := range []string{"1 / 0", `1 == "a"`, "true + 1"} {
+		e, err := parseCondition(expr)
+		assertNoError(err, t, "parseCondition()")
+
+		if _, err := g.evalExpr(e); err == nil {
+			t.Fatalf("%s: expected an error", expr)
+		}
+	}
+
+	if _, err := parseCondition("i =="); err == nil {
+		t.Fatal("expected a parse error")
+	}
+}
+
+func TestEvalMixedSigns(t *testing.T) {
+	testcases := []struct {
+		op    token.Token
+		x, y  interface{}
+		value interface{}
+	}{
+		{token.GTR, uint64(0), int64(-1), true},
+		{token.EQL, uint64(math.MaxUint64), int64(-1), false},
+		{token.LSS, int64(-1), uint64(0), true},
+		{token.NEQ, int64(-5), uint64(3), true},
+		{token.GTR, int64(5), uint64(3), true},
+		{token.ADD, uint64(1), int64(2), uint64(3)},
+	}
+
+	for _, tc := range testcases {
+		val, err := evalBinary(tc.op, tc.x, tc.y)
+		assertNoError(err, t, "evalBinary()")
+		if val != tc.value {
+			t.Fatalf("%v %s %v: expected %v got %v", tc.x, tc.op, tc.y, tc.value, val)
+		}
+	}
+
+	if _, err := evalBinary(token.ADD, uint64(1), int64(-1)); err == nil {
+		t.Fatal("expected an error adding a negative value to an unsigned one")
+	}
+}
+
+func TestEncodeValue(t *testing.T) {
+	int8Type := &dwarf.IntType{BasicType: dwarf.BasicType{CommonType: dwarf.CommonType{ByteSize: 1, Name: "int8"}}}
+	uint16Type := &dwarf.UintType{BasicType: dwarf.BasicType{CommonType: dwarf.CommonType{ByteSize: 2, Name: "uint16"}}}
+	float32Type := &dwarf.FloatType{BasicType: dwarf.BasicType{CommonType: dwarf.CommonType{ByteSize: 4, Name: "float32"}}}
+	boolType := &dwarf.BoolType{BasicType: dwarf.BasicType{CommonType: dwarf.CommonType{ByteSize: 1, Name: "bool"}}}
+
+	testcases := []struct {
+		value interface{}
+		typ   dwarf.Type
+		bytes []byte
+	}{
+		{int64(-2), int8Type, []byte{0xfe}},
+		{int64(513), uint16Type, []byte{0x01, 0x02}},
+		{int64(1), float32Type, []byte{0x00, 0x00, 0x80, 0x3f}},
+		{true, boolType, []byte{0x01}},
+	}
+	for _, tc := range testcases {
+		b, err := encodeValue(tc.value, tc.typ)
+		assertNoError(err, t, "encodeValue()")
+		if !bytes.Equal(b, tc.bytes) {
+			t.Fatalf("%v as %s: expected %#v got %#v", tc.value, tc.typ, tc.bytes, b)
+		}
+	}
+
+	for _, tc := range []struct {
+		value interface{}
+		typ   dwarf.Type
+	}{
+		{int64(128), int8Type},
+		{int64(-1), uint16Type},
+		{true, int8Type},
+		{1.5, boolType},
+		{"a", int8Type},
+	} {
+		if _, err := encodeValue(tc.value, tc.typ); err == nil {
+			t.Fatalf("%v as %s: expected an error", tc.value, tc.typ)
+		}
+	}
+}
@@ -0,0 +1,289 @@
+package proctl
+
+import (
+	"debug/dwarf"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Values of runtime.g.atomicstatus, see runtime/runtime2.go.
+const (
+	gIdle = iota
+	gRunnable
+	gRunning
+	gSyscall
+	gWaiting
+	gMoribundUnused
+	gDead
+	gEnqueueUnused
+	gCopystack
+	gPreempted
+
+	gScan = 0x1000 //set while the GC scans the stack
+)
+
+var gStatusNames = map[uint64]string{
+	gIdle:      "idle",
+	gRunnable:  "runnable",
+	gRunning:   "running",
+	gSyscall:   "syscall",
+	gWaiting:   "waiting",
+	gDead:      "dead",
+	gCopystack: "copystack",
+	gPreempted: "preempted",
+}
+
+// Scheduler state of a goroutine, read from its runtime.g.
+type goroutineState struct {
+	id         int
+	status     uint64
+	waitreason string
+	waitsince  int64 //nanotime the goroutine blocked at, 0 if unknown
+	tid        int   //thread of the M running the goroutine, 0 if none
+	gopc       uint64
+	startpc    uint64
+	sched      schedRegs
+}
+
+func (s *goroutineState) statusString() string {
+	if name, ok := gStatusNames[s.status&^gScan]; ok {
+		return name
+	}
+	return fmt.Sprintf("status %d", s.status)
+}
+
+// Returns how long the goroutine has been waiting, 0 when unknown.
+func (s *goroutineState) waitTime(dbp *DebuggedProcess) time.Duration {
+	if dbp.core || s.waitsince <= 0 || s.status&^gScan != gWaiting {
+		return 0
+	}
+
+	now := monotonicTime()
+	if now < s.waitsince {
+		return 0
+	}
+	return time.Duration(now-s.waitsince) / time.Millisecond * time.Millisecond
+}
+
+// A location of a goroutine: current, user, go or start.
+type goroutineLocation struct {
+	kind     string
+	location string
+}
+
+// Reads the state of every goroutine in runtime.allg.
+func (dbp *DebuggedProcess) goroutineStates() ([]*goroutineState, error) {
+	layouts, err := dbp.runtimeLayouts()
+	if err != nil {
+		return nil, err
+	}
+
+	reader := dbp.Dwarf.Reader()
+	allglen, err := allglenval(dbp, reader)
+	if err != nil {
+		return nil, err
+	}
+	allgaddr, err := dbp.getAllgaddr(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	reasons := dbp.waitReasons()
+
+	states := make([]*goroutineState, 0, allglen)
+	for i := uint64(0); i < allglen; i++ {
+		gaddr, err := dbp.readUint64(allgaddr + i*uint64(ptrsize))
+		if err != nil {
+			return nil, err
+		}
+
+		s, err := dbp.readGoroutineState(layouts, gaddr, reasons)
+		if err != nil {
+			return nil, err
+		}
+		states = append(states, s)
+	}
+
+	return states, nil
+}
+
+func (dbp *DebuggedProcess) readGoroutineState(layouts *runtimeLayouts, gaddr uint64, reasons []string) (*goroutineState, error) {
+	g, gobuf := layouts.g, layouts.gobuf
+
+	data, err := g.read(dbp, gaddr)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &goroutineState{}
+
+	id, err := g.uint(data, "goid")
+	if err != nil {
+		return nil, err
+	}
+	s.id = int(id)
+
+	if s.status, err = g.uint(data, "atomicstatus"); err != nil {
+		return nil, err
+	}
+	if s.gopc, err = g.uint(data, "gopc"); err != nil {
+		return nil, err
+	}
+	if s.startpc, err = g.uint(data, "startpc"); err != nil {
+		return nil, err
+	}
+
+	sched, err := g.bytes(data, "sched")
+	if err != nil {
+		return nil, err
+	}
+	if s.sched.sp, err = gobuf.uint(sched, "sp"); err != nil {
+		return nil, err
+	}
+	if s.sched.pc, err = gobuf.uint(sched, "pc"); err != nil {
+		return nil, err
+	}
+	//frame pointers are saved by newer runtimes only
+	if gobuf.has("bp") {
+		if s.sched.bp, err = gobuf.uint(sched, "bp"); err != nil {
+			return nil, err
+		}
+	}
+
+	if g.has("waitsince") {
+		since, err := g.uint(data, "waitsince")
+		if err != nil {
+			return nil, err
+		}
+		s.waitsince = int64(since)
+	}
+
+	if g.has("waitreason") && s.status&^gScan == gWaiting {
+		f, _ := g.field("waitreason")
+		if st, ok := f.Type.(*dwarf.StructType); ok && st.StructName == "string" {
+			gr := &Goroutine{dbp: dbp}
+			if s.waitreason, err = gr.readString(uintptr(gaddr + uint64(f.ByteOffset))); err != nil {
+				return nil, err
+			}
+		} else {
+			n, err := g.uint(data, "waitreason")
+			if err != nil {
+				return nil, err
+			}
+			if n < uint64(len(reasons)) {
+				s.waitreason = reasons[n]
+			} else {
+				s.waitreason = fmt.Sprintf("wait reason %d", n)
+			}
+		}
+	}
+
+	//the thread id of an M is only known on Linux
+	m, err := g.uint(data, "m")
+	if err != nil {
+		return nil, err
+	}
+	if m != 0 && layouts.m.has("procid") {
+		mdata, err := layouts.m.read(dbp, m)
+		if err != nil {
+			return nil, err
+		}
+		tid, err := layouts.m.uint(mdata, "procid")
+		if err != nil {
+			return nil, err
+		}
+		s.tid = int(tid)
+	}
+
+	return s, nil
+}
+
+// Returns runtime.waitReasonStrings, used by runtimes where
+// g.waitreason is a number.
+func (dbp *DebuggedProcess) waitReasons() []string {
+	entry, err := findDwarfEntry("runtime.waitReasonStrings", dbp.Dwarf.Reader(), false)
+	if err != nil {
+		return nil
+	}
+
+	off, ok := entry.Val(dwarf.AttrType).(dwarf.Offset)
+	if !ok {
+		return nil
+	}
+	typ, err := dbp.Dwarf.Type(off)
+	if err != nil {
+		return nil
+	}
+	at, ok := typ.(*dwarf.ArrayType)
+	if !ok {
+		return nil
+	}
+
+	addr, err := addressFor(dbp, "runtime.waitReasonStrings", dbp.Dwarf.Reader())
+	if err != nil {
+		return nil
+	}
+
+	g := &Goroutine{dbp: dbp}
+	reasons := make([]string, 0, at.Count)
+	for i := int64(0); i < at.Count; i++ {
+		r, err := g.readString(uintptr(addr + uint64(i)*2*uint64(ptrsize)))
+		if err != nil {
+			return nil
+		}
+		reasons = append(reasons, r)
+	}
+	return reasons
+}
+
+// Returns a Goroutine using the registers of the thread running s, or
+// its saved context when it is not running.
+func (dbp *DebuggedProcess) goroutineOf(s *goroutineState) *Goroutine {
+	if s.tid != 0 && s.status&^gScan == gRunning {
+		if _, err := dbp.registers(s.tid); err == nil {
+			return &Goroutine{dbp: dbp, id: s.id, tid: s.tid}
+		}
+	}
+
+	sched := s.sched
+	return &Goroutine{dbp: dbp, id: s.id, tid: -1, sched: &sched}
+}
+
+// Returns the current location of s, the first one in user code, the
+// go statement that created it and its start function.
+func (dbp *DebuggedProcess) goroutineLocations(s *goroutineState) []goroutineLocation {
+	var locs []goroutineLocation
+
+	if s.status&^gScan != gDead {
+		frames, err := dbp.goroutineOf(s).unwind(50)
+		if err == nil && len(frames) > 0 {
+			locs = append(locs, goroutineLocation{"current", dbp.pcLocation(frames[0].lookupPC)})
+
+			for i := range frames {
+				if !strings.HasPrefix(frames[i].Function, "runtime.") {
+					locs = append(locs, goroutineLocation{"user", dbp.pcLocation(frames[i].lookupPC)})
+					break
+				}
+			}
+		}
+	}
+
+	if s.gopc != 0 {
+		//gopc is the return address of the call to newproc
+		locs = append(locs, goroutineLocation{"go", dbp.pcLocation(s.gopc - 1)})
+	}
+	if s.startpc != 0 {
+		locs = append(locs, goroutineLocation{"start", dbp.pcLocation(s.startpc)})
+	}
+
+	return locs
+}
+
+func (dbp *DebuggedProcess) pcLocation(pc uint64) string {
+	f, l, fn := dbp.GoSymTable.PCToLine(pc)
+	if fn == nil {
+		return fmt.Sprintf("%#v", pc)
+	}
+	return fmt.Sprintf("%s:%d %s", f, l, fn.Name)
+}
@@ -1,5 +1,20 @@
 package proctl
 
+import (
+	"debug/gosym"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"runtime"
+
+	"github.com/chendesheng/delve/dwarf/frame"
+)
+
+const (
+	FLAGS_TF = 0x100 // x86 single-step processor flag
+)
+
 type Registers interface {
 	PC() uint64
 	SP() uint64
@@ -15,18 +30,62 @@ type Goroutine struct {
 	chwait chan struct{}
 	chcont chan *waitarg
 
+	//User breakpoint the goroutine is stopped at, if any, and the
+	//number of that stop
+	breakpoint     *Breakpoint
+	breakpointStop int
+
+	//Frame variables are evaluated in, nil means the innermost one
+	frame      *Stackframe
+	frameIndex int
+
+	//Context saved in g.sched when the goroutine is parked, nil when
+	//it runs on thread tid
+	sched *schedRegs
+
+	//Stopped on its way to a fatal panic or error already, the next
+	//runtime functions on that path do not stop it again
+	fatal bool
+
+	//Running a function injected by Call, the stops of the goroutine
+	//the runtime runs it on are its stops
+	calling bool
+
 	//Record before single step
 	//After single step we need write 0xcc back to lastPC if there is a breakpoint there
 	lastPC uint64
 }
 
+// Registers of a parked goroutine, as saved by the scheduler. They
+// are read only, the goroutine is resumed by the runtime.
+type schedRegs struct {
+	pc, sp, bp uint64
+}
+
+var errParked = errors.New("goroutine is parked, its registers can not be changed")
+
+func (r *schedRegs) PC() uint64                  { return r.pc }
+func (r *schedRegs) SP() uint64                  { return r.sp }
+func (r *schedRegs) Rflags() uint64              { return 0 }
+func (r *schedRegs) SetPC(int, uint64) error     { return errParked }
+func (r *schedRegs) SetRflags(int, uint64) error { return errParked }
+
 type waitarg struct {
 	chwait chan struct{}
 	typ    int
 }
 
+// Returns the registers of the thread running g, or the saved context
+// of a parked goroutine.
+func (g *Goroutine) registers() (Registers, error) {
+	if g.sched != nil {
+		return g.sched, nil
+	}
+	return g.dbp.registers(g.tid)
+}
+
 func (g *Goroutine) pc() (uint64, error) {
-	regs, err := registers(g.tid)
+	regs, err := g.registers()
 	if err != nil {
 		return 0, err
 	}
@@ -34,3 +93,489 @@ func (g *Goroutine) pc() (uint64, error) {
 	return regs.PC(), nil
 
 }
+
+func (g *Goroutine) next() error {
+	log.Print("next()")
+
+	pc, err := g.pc()
+	if err != nil {
+		return err
+	}
+
+	fde, err := g.dbp.FrameEntries.FDEForPC(pc)
+	if err != nil {
+		return err
+	}
+
+	_, l, _ := g.dbp.GoSymTable.PCToLine(pc)
+	ret := g.ReturnAddressFromOffset(fde.ReturnAddressOffset(pc))
+	for {
+		if err = g.stepInstruction(); err != nil {
+			return err
+		}
+
+		regs, err := g.dbp.registers(g.tid)
+		if err != nil {
+			return err
+		}
+		//pc := regs.PC()
+		pc, rflags := regs.PC(), regs.Rflags()
+		log.Printf("pc: 0x%x", pc)
+		log.Printf("rflags: 0x%x", rflags)
+		log.Printf("ret: 0x%x", ret)
+
+		if !fde.Cover(pc) && pc != ret { //goto different function
+			if err := g.continueToReturnAddress(pc, fde); err != nil {
+				if _, ok := err.(InvalidAddressError); !ok {
+					return err
+				}
+			}
+			if pc, err = g.pc(); err != nil {
+				return err
+			}
+		}
+
+		if _, nl, _ := g.dbp.GoSymTable.PCToLine(pc); nl != l {
+			log.Printf("line:%d", nl)
+			break
+		}
+	}
+
+	return nil
+}
+
+// Runs g until the source line changes like next, but follows calls
+// into functions with line information and stops at their first
+// statement, after the prologue. Functions step filters match are
+// stepped through to the user function they call, if any.
+func (g *Goroutine) stepInto() error {
+	pc, err := g.pc()
+	if err != nil {
+		return err
+	}
+
+	fde, err := g.dbp.FrameEntries.FDEForPC(pc)
+	if err != nil {
+		return err
+	}
+
+	f, l, _ := g.dbp.GoSymTable.PCToLine(pc)
+	ret := g.ReturnAddressFromOffset(fde.ReturnAddressOffset(pc))
+
+	//the filtered function being stepped through, fde and ret are the
+	//ones of its frame meanwhile
+	var (
+		filtered *gosym.Func
+		outerFde *frame.FrameDescriptionEntry
+		outerRet uint64
+	)
+	for {
+		if err = g.stepInstruction(); err != nil {
+			return err
+		}
+
+		if pc, err = g.pc(); err != nil {
+			return err
+		}
+
+		if !fde.Cover(pc) && pc != ret {
+			fn := g.dbp.GoSymTable.PCToFunc(pc)
+			if fn != nil && fn.Entry == pc {
+				if !g.dbp.stepFiltered(fn) {
+					return g.continueToFirstStatement(fn)
+				}
+
+				//functions it calls in turn are run to their return
+				if filtered == nil {
+					if ffde, err := g.dbp.FrameEntries.FDEForPC(pc); err == nil {
+						log.Printf("step through %s", fn.Name)
+						outerFde, outerRet = fde, ret
+						fde, ret, filtered = ffde, g.ReturnAddressFromOffset(0), fn
+						continue
+					}
+				}
+			}
+
+			//not a call, e.g. a jump into the runtime, go on after it
+			if err := g.continueToReturnAddress(pc, fde); err != nil {
+				if _, ok := err.(InvalidAddressError); !ok {
+					return err
+				}
+			}
+			if g.breakpoint != nil {
+				//stopped at a user breakpoint on the way
+				return nil
+			}
+			if pc, err = g.pc(); err != nil {
+				return err
+			}
+		}
+
+		if filtered != nil {
+			if pc != ret {
+				continue
+			}
+			//back on the line being stepped
+			fde, ret, filtered = outerFde, outerRet, nil
+		}
+
+		if nf, nl, _ := g.dbp.GoSymTable.PCToLine(pc); nf != f || nl != l {
+			log.Printf("line:%s:%d", nf, nl)
+			return nil
+		}
+	}
+}
+
+// Runs g, stopped at the entry of fn, past the prologue of fn.
+func (g *Goroutine) continueToFirstStatement(fn *gosym.Func) error {
+	addr := g.dbp.firstStatement(fn)
+	if addr == fn.Entry {
+		return nil
+	}
+
+	log.Printf("set breakpoint after the prologue of %s:%#v, goroutine %d", fn.Name, addr, g.id)
+	if _, err := g.dbp.setBreakpoint(addr, g.id); err != nil {
+		return err
+	}
+
+	defer func() {
+		if _, err := g.dbp.clearBreakpoint(addr, g.id); err != nil {
+			log.Print(err)
+		}
+	}()
+
+	return g.cont()
+}
+
+// Returns the address of the first statement of fn. The prologue,
+// stack check and frame setup, is on the line of the func keyword, the
+// body starts at the first address on another line.
+func (dbp *DebuggedProcess) firstStatement(fn *gosym.Func) uint64 {
+	_, l, _ := dbp.GoSymTable.PCToLine(fn.Entry)
+	for pc := fn.Entry + 1; pc < fn.End; pc++ {
+		if _, nl, _ := dbp.GoSymTable.PCToLine(pc); nl != l {
+			return pc
+		}
+	}
+
+	return fn.Entry
+}
+
+func (g *Goroutine) step() error {
+	log.Print("step()")
+
+	regs, err := g.dbp.registers(g.tid)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("enable single step:%d", g.id)
+	if err := regs.SetRflags(g.tid, regs.Rflags()|FLAGS_TF); err != nil {
+		return fmt.Errorf("step failed: %s", err.Error())
+	}
+
+	//handle step through a breakpoint
+	if bp, ok := g.dbp.Breakpoints[regs.PC()]; ok {
+		if _, err := g.dbp.writeMemory(uintptr(bp.Addr), bp.OriginalData); err != nil {
+			return err
+		}
+	}
+
+	g.lastPC = regs.PC()
+
+	return g.cont()
+}
+
+// Steps g over the instruction at its pc. A signal delivered to the
+// thread meanwhile, e.g. the one of asynchronous preemption, makes the
+// step end in the handler, g is run on until it is back at the
+// instruction in that case.
+func (g *Goroutine) stepInstruction() error {
+	regs, err := g.registers()
+	if err != nil {
+		return err
+	}
+	pc, sp := regs.PC(), regs.SP()
+
+	if err := g.step(); err != nil {
+		return err
+	}
+
+	if regs, err = g.registers(); err != nil {
+		return err
+	}
+	newpc := regs.PC()
+	if !g.dbp.stepDiverted(pc, sp, newpc, regs.SP()) {
+		return nil
+	}
+
+	log.Printf("diverted to %#v, set breakpoint at %#v, goroutine %d", newpc, pc, g.id)
+	if _, err := g.dbp.setBreakpoint(pc, g.id); err != nil {
+		return err
+	}
+
+	defer func() {
+		if _, err := g.dbp.clearBreakpoint(pc, g.id); err != nil {
+			log.Print(err)
+		}
+	}()
+
+	return g.cont()
+}
+
+func removeSingleStep(tid int, regs Registers) (bool, error) {
+	if rflags := regs.Rflags(); rflags&FLAGS_TF != 0 {
+		if err := regs.SetRflags(tid, rflags&^FLAGS_TF); err != nil {
+			return false, err
+		}
+		return true, nil
+	}
+
+	return false, nil
+}
+
+var ErrInterrupt = errors.New("Interrupt")
+
+//Wait until receive an interrupt
+func (g *Goroutine) wait() error {
+	log.Println("wait()")
+
+	if arg, ok := <-g.chcont; ok {
+		g.chwait = arg.chwait
+
+		regs, err := g.dbp.registers(g.tid)
+		if err != nil {
+			return err
+		}
+
+		log.Printf("remove single step:%d", g.id)
+		isSingleStep, err := removeSingleStep(g.tid, regs)
+		if err != nil {
+			return err
+		}
+
+		if arg.typ == TE_MANUAL || arg.typ == TE_SIGNAL {
+			log.Print("return ErrInterrupt")
+			return ErrInterrupt
+		}
+
+		if arg.typ == TE_BREAKPOINT {
+			//Data watchpoints trap after the access, whatever else
+			//happened the goroutine stops here if one triggered
+			watchHit, err := g.dbp.checkWatchpoints(g)
+			if err != nil {
+				return err
+			}
+			var result error
+			if watchHit {
+				result = ErrInterrupt
+			}
+
+			//There are several conditions here
+			// 1) Hit a breakpoint set by debugger
+			// 2) Hit a breakpoint compiled into the program, e.g. runtime.Breakpoint()
+			// 3) Step single instruction passing 0xcc
+			// 4) Triggered a watchpoint
+			if isSingleStep {
+				if bp, ok := g.dbp.Breakpoints[g.lastPC]; ok {
+					g.lastPC = 0
+
+					mem, err := g.dbp.readMemory(uintptr(bp.Addr), 1)
+					if err != nil {
+						return err
+					}
+
+					if mem[0] != 0xcc {
+						if _, err := g.dbp.writeMemory(uintptr(bp.Addr), []byte{0xcc}); err != nil {
+							return err
+						}
+					}
+					return result
+				}
+			}
+
+			if watchHit {
+				return result
+			}
+
+			if bp, ok := g.dbp.Breakpoints[regs.PC()-1]; ok {
+				log.Print("fix temp breakpoint")
+
+				mem, err := g.dbp.readMemory(uintptr(bp.Addr), 1)
+				if err != nil {
+					return err
+				}
+
+				if mem[0] == 0xcc {
+					if _, err := g.dbp.writeMemory(uintptr(bp.Addr), bp.OriginalData); err != nil {
+						return err
+					}
+
+					// Reset program counter to our restored instruction.
+					err = regs.SetPC(g.tid, bp.Addr)
+					if err != nil {
+						return fmt.Errorf("could not set registers %s", err)
+					}
+
+					if bp.belongsTo(panicGoroutines) && !g.fatal {
+						//the goroutine takes the process down, stop
+						//whatever the other breakpoints here say
+						g.fatal = true
+						g.printFatalError(bp)
+						return nil
+					}
+
+					if !g.stopsAt(bp) {
+						//a temp breakpoint of another goroutine, or the
+						//condition or ignore count say to keep going
+						if err := g.step(); err != nil {
+							return err
+						}
+
+						log.Print("continue to wait")
+						return g.cont()
+					}
+
+					skip, err := g.dbp.checkWatchScopes(g, bp)
+					if err != nil {
+						return err
+					}
+					if skip {
+						//the watched frame has not returned yet
+						if err := g.step(); err != nil {
+							return err
+						}
+
+						return g.cont()
+					}
+				}
+			} else if !isSingleStep && !g.calling {
+				hit, err := g.hardcodedBreakpoint(regs.PC())
+				if err != nil {
+					return err
+				}
+				if hit {
+					return ErrInterrupt
+				}
+			}
+		}
+	} else {
+		runtime.Goexit()
+	}
+
+	return nil
+}
+
+//continue and wait
+func (g *Goroutine) cont() error {
+	//log.Print(string(debug.Stack()))
+	log.Print("cont()")
+
+	g.breakpoint = nil
+	g.frame, g.frameIndex = nil, 0
+	g.dbp.selectedGoroutine = nil
+	g.chwait <- struct{}{}
+	if err := g.wait(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Takes an offset from RSP and returns the address of the
+// instruction the currect function is going to return to.
+func (g *Goroutine) ReturnAddressFromOffset(offset int64) uint64 {
+	regs, err := g.registers()
+	if err != nil {
+		panic("Could not obtain register values")
+	}
+
+	retaddr := int64(regs.SP()) + offset
+	data, err := g.dbp.readMemory(uintptr(retaddr), 8)
+	if err != nil {
+		panic("Could not read from memory")
+	}
+	return binary.LittleEndian.Uint64(data)
+}
+
+func (g *Goroutine) continueToReturnAddress(pc uint64, fde *frame.FrameDescriptionEntry) error {
+	// Our offset here is be 0 because we
+	// have stepped into the first instruction
+	// of this function. Therefore the function
+	// has not had a chance to modify its' stack
+	// and change our offset.
+	addr := g.ReturnAddressFromOffset(0)
+
+	log.Printf("set breakpoint at return address:%#v, goroutine %d", addr, g.id)
+	if _, err := g.dbp.setBreakpoint(addr, g.id); err != nil {
+		return err
+	}
+
+	// Ensure we cleanup after ourselves no matter what.
+	defer func() {
+		if _, err := g.dbp.clearBreakpoint(addr, g.id); err != nil {
+			log.Print(err)
+		}
+	}()
+
+	return g.cont()
+}
+
+// Runs g until the selected frame returns to its caller and returns
+// the results of the function that returned. Results are nil when g
+// stopped somewhere else first, e.g. at a user breakpoint.
+func (g *Goroutine) stepOut() ([]*Variable, error) {
+	frames, err := g.unwind(g.frameIndex + 2)
+	if err != nil {
+		return nil, err
+	}
+	if len(frames) == 0 {
+		return nil, fmt.Errorf("goroutine %d has no frames to step out of", g.id)
+	}
+	if len(frames) < g.frameIndex+2 {
+		return nil, fmt.Errorf("%s has no caller to return to", frames[len(frames)-1].Function)
+	}
+
+	callee, ret := frames[g.frameIndex], frames[g.frameIndex+1].PC
+
+	log.Printf("set breakpoint at return address:%#v, goroutine %d", ret, g.id)
+	if _, err := g.dbp.setBreakpoint(ret, g.id); err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		if _, err := g.dbp.clearBreakpoint(ret, g.id); err != nil {
+			log.Print(err)
+		}
+	}()
+
+	if err := g.cont(); err != nil {
+		return nil, err
+	}
+
+	for {
+		regs, err := g.dbp.registers(g.tid)
+		if err != nil {
+			return nil, err
+		}
+
+		if regs.PC() != ret {
+			return nil, nil
+		}
+
+		//the stack pointer is back at the CFA once the frame is popped
+		if regs.SP() >= callee.CFA {
+			break
+		}
+
+		//a recursive call returned first, step past the breakpoint
+		if err := g.step(); err != nil {
+			return nil, err
+		}
+		if err := g.cont(); err != nil {
+			return nil, err
+		}
+	}
+
+	return g.inFrame(&callee).functionResults()
+}
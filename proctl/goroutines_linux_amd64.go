@@ -0,0 +1,110 @@
+package proctl
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+// Size of the FXSAVE area PTRACE_GETFPREGS reads and the offset of
+// XMM0 in it, each XMM register takes 16 bytes.
+const (
+	fpregsSize = 512
+	xmmOffset  = 160
+)
+
+// Offset from the FS base of the TLS slot the runtime keeps the
+// current g in, runtime.tlsg for internally linked binaries.
+const tlsGOffset = -8
+
+type Regs syscall.PtraceRegs
+
+func (r *Regs) PC() uint64 {
+	return r.Rip
+}
+
+func (r *Regs) SP() uint64 {
+	return r.Rsp
+}
+
+func (r *Regs) SetPC(tid int, pc uint64) error {
+	r.Rip = pc
+	return setregs(tid, r)
+}
+
+func (r *Regs) Rflags() uint64 {
+	return r.Eflags
+}
+
+func (r *Regs) SetRflags(tid int, rflags uint64) error {
+	r.Eflags = rflags
+	return setregs(tid, r)
+}
+
+func registers(tid int) (Registers, error) {
+	var (
+		r   Regs
+		err error
+	)
+	execPtraceFunc(func() { err = syscall.PtraceGetRegs(tid, (*syscall.PtraceRegs)(&r)) })
+	if err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+func setregs(tid int, r *Regs) error {
+	var err error
+	execPtraceFunc(func() { err = syscall.PtraceSetRegs(tid, (*syscall.PtraceRegs)(r)) })
+	return err
+}
+
+// Returns the floating point and vector registers of the thread in
+// the FXSAVE layout.
+func fpregisters(tid int) ([]byte, error) {
+	data := make([]byte, fpregsSize)
+	var err error
+	execPtraceFunc(func() { err = ptraceFpregs(syscall.PTRACE_GETFPREGS, tid, data) })
+	if err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+func setFpregisters(tid int, data []byte) error {
+	var err error
+	execPtraceFunc(func() { err = ptraceFpregs(syscall.PTRACE_SETFPREGS, tid, data) })
+	return err
+}
+
+func ptraceFpregs(req, tid int, data []byte) error {
+	_, _, errno := syscall.Syscall6(syscall.SYS_PTRACE, uintptr(req), uintptr(tid), 0, uintptr(unsafe.Pointer(&data[0])), 0, 0)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// Returns the address of the g the thread is running, read from the
+// runtime's TLS slot. It is g0 while the thread is in the scheduler.
+func (dbp *DebuggedProcess) tlsG(tid int) (uint64, error) {
+	regs, err := dbp.registers(tid)
+	if err != nil {
+		return 0, err
+	}
+
+	var fsbase uint64
+	switch r := regs.(type) {
+	case *Regs:
+		fsbase = r.Fs_base
+	case *coreRegs:
+		//NT_PRSTATUS has the registers of PTRACE_GETREGS
+		fsbase = r.regs.Fs_base
+	default:
+		return 0, errNoTLS
+	}
+	if fsbase == 0 {
+		return 0, nil
+	}
+
+	return dbp.readUint64(uint64(int64(fsbase) + tlsGOffset))
+}
@@ -0,0 +1,167 @@
+package proctl
+
+import (
+	"debug/dwarf"
+	"encoding/binary"
+	"fmt"
+	"strings"
+)
+
+// Layout of a runtime struct type, resolved from the DWARF types of the
+// binary so it follows the runtime across Go releases.
+type structLayout struct {
+	name   string
+	size   int64
+	fields map[string]*dwarf.StructField
+}
+
+// Layouts of the scheduler structures and of the type descriptors
+// interfaces point to, resolved once per process.
+type runtimeLayouts struct {
+	g, m, p, stack, gobuf *structLayout
+	typ                   *structLayout
+}
+
+func (dbp *DebuggedProcess) runtimeLayouts() (*runtimeLayouts, error) {
+	if dbp.layouts != nil {
+		return dbp.layouts, nil
+	}
+
+	l := &runtimeLayouts{}
+	for _, t := range []struct {
+		names  []string //the first one the binary has is used
+		layout **structLayout
+	}{
+		{[]string{"runtime.g"}, &l.g},
+		{[]string{"runtime.m"}, &l.m},
+		{[]string{"runtime.p"}, &l.p},
+		{[]string{"runtime.stack"}, &l.stack},
+		{[]string{"runtime.gobuf"}, &l.gobuf},
+		{[]string{"internal/abi.Type", "runtime._type"}, &l.typ}, //moved to internal/abi in Go 1.21
+	} {
+		layout, err := dbp.anyStructLayout(t.names...)
+		if err != nil {
+			return nil, err
+		}
+		*t.layout = layout
+	}
+
+	dbp.layouts = l
+	return l, nil
+}
+
+func (dbp *DebuggedProcess) structLayout(name string) (*structLayout, error) {
+	entry, err := findDwarfEntry(name, dbp.Dwarf.Reader(), false)
+	if err != nil {
+		return nil, err
+	}
+	if entry.Tag != dwarf.TagStructType {
+		return nil, fmt.Errorf("%s is not a struct type", name)
+	}
+
+	typ, err := dbp.Dwarf.Type(entry.Offset)
+	if err != nil {
+		return nil, err
+	}
+	st, ok := typ.(*dwarf.StructType)
+	if !ok {
+		return nil, fmt.Errorf("%s is not a struct type", name)
+	}
+
+	l := &structLayout{
+		name:   name,
+		size:   st.Size(),
+		fields: make(map[string]*dwarf.StructField, len(st.Field)),
+	}
+	for _, f := range st.Field {
+		l.fields[f.Name] = f
+	}
+	return l, nil
+}
+
+// Returns the layout of the first of the named structs the binary has,
+// for runtime types that were renamed or moved.
+func (dbp *DebuggedProcess) anyStructLayout(names ...string) (*structLayout, error) {
+	var err error
+	for _, name := range names {
+		var l *structLayout
+		if l, err = dbp.structLayout(name); err == nil {
+			return l, nil
+		}
+	}
+	return nil, err
+}
+
+// Returns the named field, the error names the field when the runtime
+// of the program does not have it.
+func (l *structLayout) field(name string) (*dwarf.StructField, error) {
+	f, ok := l.fields[name]
+	if !ok {
+		return nil, fmt.Errorf("%s has no field %s, unsupported Go version", l.name, name)
+	}
+	return f, nil
+}
+
+func (l *structLayout) has(name string) bool {
+	_, ok := l.fields[name]
+	return ok
+}
+
+// Reads the struct at addr.
+func (l *structLayout) read(dbp *DebuggedProcess, addr uint64) ([]byte, error) {
+	data, err := dbp.readMemory(uintptr(addr), int(l.size))
+	if err != nil {
+		return nil, fmt.Errorf("could not read %s at %#v: %s", l.name, addr, err)
+	}
+	return data, nil
+}
+
+// Returns the bytes of the named field of the struct in data.
+func (l *structLayout) bytes(data []byte, name string) ([]byte, error) {
+	f, err := l.field(name)
+	if err != nil {
+		return nil, err
+	}
+
+	end := f.ByteOffset + f.Type.Size()
+	if end > int64(len(data)) {
+		return nil, fmt.Errorf("%s.%s is out of bounds", l.name, name)
+	}
+	return data[f.ByteOffset:end], nil
+}
+
+// Returns the named integer, bool or pointer field of the struct in
+// data.
+func (l *structLayout) uint(data []byte, name string) (uint64, error) {
+	b, err := l.bytes(data, name)
+	if err != nil {
+		return 0, err
+	}
+	return decodeUint(b)
+}
+
+// Returns the first of the named integer fields the struct in data has,
+// for fields that were renamed across Go releases.
+func (l *structLayout) anyUint(data []byte, names ...string) (uint64, error) {
+	for _, name := range names {
+		if l.has(name) {
+			return l.uint(data, name)
+		}
+	}
+	return 0, fmt.Errorf("%s has none of the fields %s, unsupported Go version", l.name, strings.Join(names, ", "))
+}
+
+// Decodes an unsigned little endian integer of 1, 2, 4 or 8 bytes.
+func decodeUint(data []byte) (uint64, error) {
+	switch len(data) {
+	case 1:
+		return uint64(data[0]), nil
+	case 2:
+		return uint64(binary.LittleEndian.Uint16(data)), nil
+	case 4:
+		return uint64(binary.LittleEndian.Uint32(data)), nil
+	case 8:
+		return binary.LittleEndian.Uint64(data), nil
+	}
+	return 0, fmt.Errorf("unsupported integer size %d", len(data))
+}
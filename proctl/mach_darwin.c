@@ -56,6 +56,21 @@ int setregs(int tid, Regs* regs) {
         return KERN_SUCCESS;
 }
 
+int getdebugregs(int tid, DebugRegs* regs) {
+        mach_msg_type_number_t stateCount = x86_DEBUG_STATE64_COUNT;
+        kern_return_t kret = thread_get_state(tid, x86_DEBUG_STATE64, (thread_state_t)regs, &stateCount);
+        CHECK_KRET(kret);
+
+        return KERN_SUCCESS;
+}
+
+int setdebugregs(int tid, DebugRegs* regs) {
+        kern_return_t kret = thread_set_state(tid, x86_DEBUG_STATE64, (thread_state_t)regs, x86_DEBUG_STATE64_COUNT);
+        CHECK_KRET(kret);
+
+        return KERN_SUCCESS;
+}
+
 int vmread(int pid, ulong addr, int size, void* data, ulong* outsz) {
         int task;
         kern_return_t kret;
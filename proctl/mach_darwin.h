@@ -5,12 +5,15 @@
 #include <stdio.h>
 
 typedef x86_thread_state64_t Regs;
+typedef x86_debug_state64_t DebugRegs;
 typedef unsigned long ulong;
 
 int gettask(int pid, int* task);
 int getthreads(int task, void* threads, int* cnt);
 int getregs(int tid, Regs* regs);
 int setregs(int tid, Regs* regs);
+int getdebugregs(int tid, DebugRegs* regs);
+int setdebugregs(int tid, DebugRegs* regs);
 int vmread(int pid, ulong addr, int size, void* data, ulong* outsz);
 int vmwrite(int pid, ulong addr, void* data, int sz);
 int attach(int pid, void* ths, int* nth);
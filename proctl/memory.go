@@ -0,0 +1,201 @@
+package proctl
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"strconv"
+)
+
+// Format of the units of examined memory.
+type MemoryFormat int
+
+const (
+	HexFormat MemoryFormat = iota
+	DecimalFormat
+	OctalFormat
+	BinaryFormat
+	CharFormat
+)
+
+var memoryFormatNames = map[string]MemoryFormat{
+	"hex":  HexFormat,
+	"dec":  DecimalFormat,
+	"oct":  OctalFormat,
+	"bin":  BinaryFormat,
+	"char": CharFormat,
+}
+
+func ParseMemoryFormat(name string) (MemoryFormat, error) {
+	if f, ok := memoryFormatNames[name]; ok {
+		return f, nil
+	}
+	return 0, fmt.Errorf("unknown format %s, expected hex, dec, oct, bin or char", name)
+}
+
+// Reads count units of size bytes at the address expr evaluates to: a
+// number, a pointer variable or &var. Breakpoints read as the
+// instructions they replaced.
+func (dbp *DebuggedProcess) ExamineMemory(expr string, size, count int) (uint64, []byte, error) {
+	switch size {
+	case 1, 2, 4, 8:
+	default:
+		return 0, nil, fmt.Errorf("invalid size %d, expected 1, 2, 4 or 8", size)
+	}
+	if count <= 0 {
+		return 0, nil, fmt.Errorf("invalid count %d", count)
+	}
+
+	t, err := parser.ParseExpr(expr)
+	if err != nil {
+		return 0, nil, fmt.Errorf("could not parse %q: %s", expr, err)
+	}
+
+	g := dbp.selected()
+	var addr uint64
+	if u, ok := t.(*ast.UnaryExpr); ok && u.Op == token.AND {
+		if addr, _, _, err = g.lvalue(u.X); err != nil {
+			return 0, nil, err
+		}
+	} else {
+		v, err := g.evalExpr(t)
+		if err != nil {
+			return 0, nil, err
+		}
+		switch a := v.(type) {
+		case int64:
+			if a < 0 {
+				return 0, nil, fmt.Errorf("invalid address %d", a)
+			}
+			addr = uint64(a)
+		case uint64:
+			addr = a
+		case pointer:
+			addr = uint64(a)
+		default:
+			return 0, nil, fmt.Errorf("%s is not an address", expr)
+		}
+	}
+
+	n := size * count
+	if addr+uint64(n) < addr {
+		return 0, nil, fmt.Errorf("%d bytes at %#x wrap around the address space", n, addr)
+	}
+	if err := dbp.checkReadable(addr, n); err != nil {
+		return 0, nil, err
+	}
+
+	data, err := dbp.readOriginalMemory(addr, n)
+	if err != nil {
+		return 0, nil, err
+	}
+	return addr, data, nil
+}
+
+// Reads memory with the original bytes in place of breakpoints.
+func (dbp *DebuggedProcess) readOriginalMemory(addr uint64, size int) ([]byte, error) {
+	data, err := dbp.readMemory(uintptr(addr), size)
+	if err != nil {
+		return nil, err
+	}
+
+	end := addr + uint64(size)
+	for _, bp := range dbp.Breakpoints {
+		for i, b := range bp.OriginalData {
+			if a := bp.Addr + uint64(i); a >= addr && a < end {
+				data[a-addr] = b
+			}
+		}
+	}
+	return data, nil
+}
+
+// Formats memory read at addr like hexdump: rows with the address,
+// the little endian units of size bytes and the bytes as ASCII.
+func FormatMemory(addr uint64, data []byte, format MemoryFormat, size int) string {
+	perRow := 16
+	if format == BinaryFormat {
+		perRow = 8
+	}
+
+	var buf bytes.Buffer
+	for row := 0; row < len(data); row += perRow {
+		end := row + perRow
+		if end > len(data) {
+			end = len(data)
+		}
+
+		fmt.Fprintf(&buf, "%#x: ", addr+uint64(row))
+		for i := row; i < row+perRow; i += size {
+			if i+size > end {
+				//keep the gutter of a short last row in line
+				buf.WriteString(" " + string(bytes.Repeat([]byte{' '}, unitWidth(format, size))))
+				continue
+			}
+			buf.WriteString(" " + formatUnit(data[i:i+size], format))
+		}
+
+		buf.WriteString("  |")
+		for _, b := range data[row:end] {
+			if b < 0x20 || b > 0x7e {
+				b = '.'
+			}
+			buf.WriteByte(b)
+		}
+		buf.WriteString("|\n")
+	}
+
+	return buf.String()
+}
+
+func formatUnit(data []byte, format MemoryFormat) string {
+	var n uint64
+	switch len(data) {
+	case 1:
+		n = uint64(data[0])
+	case 2:
+		n = uint64(binary.LittleEndian.Uint16(data))
+	case 4:
+		n = uint64(binary.LittleEndian.Uint32(data))
+	case 8:
+		n = binary.LittleEndian.Uint64(data)
+	}
+
+	width := unitWidth(format, len(data))
+	switch format {
+	case DecimalFormat:
+		//sign extend
+		shift := uint(64 - 8*len(data))
+		return fmt.Sprintf("%*d", width, int64(n<<shift)>>shift)
+	case OctalFormat:
+		return fmt.Sprintf("%0*o", width, n)
+	case BinaryFormat:
+		return fmt.Sprintf("%0*b", width, n)
+	case CharFormat:
+		s := fmt.Sprintf(`'\x%02x'`, n)
+		if n < 0x80 {
+			s = strconv.QuoteRuneToASCII(rune(n))
+		}
+		return fmt.Sprintf("%*s", width, s)
+	}
+	return fmt.Sprintf("%0*x", width, n)
+}
+
+// Returns the number of characters the widest unit of size bytes
+// takes in format.
+func unitWidth(format MemoryFormat, size int) int {
+	switch format {
+	case DecimalFormat:
+		return len(strconv.FormatInt(-1<<uint(8*size-1), 10))
+	case OctalFormat:
+		return (8*size + 2) / 3
+	case BinaryFormat:
+		return 8 * size
+	case CharFormat:
+		return len(`'\x00'`)
+	}
+	return 2 * size
+}
@@ -0,0 +1,44 @@
+package proctl
+
+import "testing"
+
+func TestFormatMemory(t *testing.T) {
+	data := []byte("Hello, world!\n\x00\xff\x01\x02")
+
+	testcases := []struct {
+		format MemoryFormat
+		size   int
+		data   []byte
+		out    string
+	}{
+		{HexFormat, 1, data,
+			"0x1000:  48 65 6c 6c 6f 2c 20 77 6f 72 6c 64 21 0a 00 ff  |Hello, world!...|\n" +
+				"0x1010:  01 02                                            |..|\n"},
+		{HexFormat, 4, data[:16], "0x1000:  6c6c6548 77202c6f 646c726f ff000a21  |Hello, world!...|\n"},
+		{DecimalFormat, 2, data[:16], "0x1000:   25928  27756  11375  30496  29295  25708   2593   -256  |Hello, world!...|\n"},
+		{OctalFormat, 8, data[:16], "0x1000:  0734401306755433062510 1774000242054433071157  |Hello, world!...|\n"},
+		{BinaryFormat, 4, data[10:18], "0x1000:  00001010001000010110010001101100 00000010000000011111111100000000  |ld!.....|\n"},
+		{CharFormat, 1, data[2:18], "0x1000:     'l'    'l'    'o'    ','    ' '    'w'    'o'    'r'    'l'    'd'    '!'   '\\n' '\\x00' '\\xff' '\\x01' '\\x02'  |llo, world!.....|\n"},
+	}
+
+	for _, tc := range testcases {
+		if out := FormatMemory(0x1000, tc.data, tc.format, tc.size); out != tc.out {
+			t.Fatalf("format %d size %d: expected\n%q\ngot\n%q", tc.format, tc.size, tc.out, out)
+		}
+	}
+}
+
+func TestFakeExamineBreakpoint(t *testing.T) {
+	withFakeProcess(t, func(p *DebuggedProcess, fb *fakeBackend) {
+		fb.WriteMemory(fakeFuncEntry, []byte{0x64, 0x48, 0x8b})
+
+		_, err := p.setBreakpoint(fakeFuncEntry+1, -1)
+		assertNoError(err, t, "setBreakpoint()")
+
+		data, err := p.readOriginalMemory(fakeFuncEntry, 3)
+		assertNoError(err, t, "readOriginalMemory()")
+		if data[1] != 0x48 {
+			t.Fatalf("Expected the original instruction under the breakpoint, got %#v", data)
+		}
+	})
+}
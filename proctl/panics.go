@@ -0,0 +1,368 @@
+package proctl
+
+import (
+	"encoding/binary"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+)
+
+// Goroutine id of the internal breakpoints that stop any goroutine about
+// to take the process down with an unrecovered panic or a fatal error.
+const panicGoroutines = -2
+
+// Runtime functions a dying program goes through, not every Go release
+// has all of them.
+var panicFunctions = []string{
+	"runtime.fatalpanic", //unrecovered panic
+	"runtime.dopanic",    //unrecovered panic, before fatalpanic existed
+	"runtime.throw",      //fatal runtime error, s is the message
+	"runtime.fatalthrow",
+}
+
+const (
+	kindMask   = 1<<5 - 1 //runtime._type.kind without the flags
+	kindString = 24
+)
+
+// Sets or clears the breakpoints stopping the process when a goroutine
+// panics without recovering or the runtime throws a fatal error.
+func (dbp *DebuggedProcess) StopOnPanic(enable bool) error {
+	if dbp.core {
+		return ErrCoreFile
+	}
+	if enable == dbp.stopOnPanic {
+		return nil
+	}
+
+	found := false
+	for _, name := range panicFunctions {
+		fn := dbp.GoSymTable.LookupFunc(name)
+		if fn == nil {
+			continue
+		}
+		found = true
+
+		var err error
+		if enable {
+			_, err = dbp.setBreakpoint(fn.Entry, panicGoroutines)
+		} else {
+			_, err = dbp.clearBreakpoint(fn.Entry, panicGoroutines)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if !found {
+		return fmt.Errorf("could not find the panic functions of the runtime")
+	}
+
+	dbp.stopOnPanic = enable
+	return nil
+}
+
+// Reports whether the process stops on unrecovered panics and fatal
+// errors.
+func (dbp *DebuggedProcess) StopsOnPanic() bool {
+	return dbp.stopOnPanic
+}
+
+// Called when g stops at the internal breakpoint bp, prints the panic
+// value or the message of the fatal error.
+func (g *Goroutine) printFatalError(bp *Breakpoint) {
+	switch bp.FunctionName {
+	case "runtime.fatalpanic", "runtime.dopanic":
+		v, err := g.panicValue()
+		if err != nil {
+			log.Printf("could not read the panic value: %s", err)
+			fmt.Printf("Goroutine %d panicked\n", g.id)
+			return
+		}
+		fmt.Printf("Goroutine %d panicked: %s\n", g.id, v)
+	case "runtime.throw":
+		msg, err := g.evalVariable("s")
+		if err != nil {
+			log.Printf("could not read the fatal error message: %s", err)
+			fmt.Printf("Fatal error in goroutine %d\n", g.id)
+			return
+		}
+		fmt.Printf("Fatal error in goroutine %d: %s\n", g.id, msg)
+	default:
+		fmt.Printf("Fatal error in goroutine %d\n", g.id)
+	}
+}
+
+// Returns the argument of the innermost panic of g, read from g._panic.
+func (g *Goroutine) panicValue() (string, error) {
+	gaddr, err := g.dbp.tlsG(g.tid)
+	if err != nil {
+		return "", err
+	}
+
+	layouts, err := g.dbp.runtimeLayouts()
+	if err != nil {
+		return "", err
+	}
+	data, err := layouts.g.read(g.dbp, gaddr)
+	if err != nil {
+		return "", err
+	}
+	p, err := layouts.g.uint(data, "_panic")
+	if err != nil {
+		return "", err
+	}
+	if p == 0 {
+		return "", fmt.Errorf("goroutine %d is not panicking", g.id)
+	}
+
+	layout, err := g.dbp.structLayout("runtime._panic")
+	if err != nil {
+		return "", err
+	}
+	arg, err := layout.field("arg")
+	if err != nil {
+		return "", err
+	}
+
+	return g.readInterface(p + uint64(arg.ByteOffset))
+}
+
+// Formats the interface{} at addr as (type) value. Values of string
+// kinds, pointers to error structs keeping their message in a string
+// field, like errors.New returns, and runtime bounds errors are decoded,
+// others print their data pointer.
+func (g *Goroutine) readInterface(addr uint64) (string, error) {
+	typ, err := g.dbp.readUint64(addr)
+	if err != nil {
+		return "", err
+	}
+	if typ == 0 {
+		return "nil", nil
+	}
+
+	data, err := g.dbp.readUint64(addr + uint64(ptrsize))
+	if err != nil {
+		return "", err
+	}
+
+	kind, name, err := g.runtimeType(typ)
+	if err != nil {
+		return "", err
+	}
+
+	switch {
+	case kind&kindMask == kindString:
+		s, err := g.readString(uintptr(data))
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("(%s) %q", name, s), nil
+	case strings.HasPrefix(name, "*") && data != 0:
+		if s, ok := g.readErrorMessage(name[1:], data); ok {
+			return fmt.Sprintf("(%s) %q", name, s), nil
+		}
+	case name == "runtime.boundsError":
+		s, err := g.readBoundsError(data)
+		if err != nil {
+			log.Printf("could not read %s: %s", name, err)
+			break
+		}
+		return fmt.Sprintf("(%s) %q", name, s), nil
+	}
+
+	return fmt.Sprintf("(%s) %#x", name, data), nil
+}
+
+// The name of a type descriptor with this tflag has an extra * in front,
+// shared with the pointer type.
+const tflagExtraStar = 1 << 1
+
+// Returns the kind and the name of the runtime type descriptor at typ.
+// The name is made up from the address when it can not be read.
+func (g *Goroutine) runtimeType(typ uint64) (uint64, string, error) {
+	layouts, err := g.dbp.runtimeLayouts()
+	if err != nil {
+		return 0, "", err
+	}
+	l := layouts.typ
+
+	raw, err := l.read(g.dbp, typ)
+	if err != nil {
+		return 0, "", err
+	}
+	kind, err := l.anyUint(raw, "Kind_", "kind")
+	if err != nil {
+		return 0, "", err
+	}
+
+	name, err := g.runtimeTypeName(l, raw)
+	if err != nil {
+		log.Printf("could not read the name of type %#x: %s", typ, err)
+		name = fmt.Sprintf("type %#x", typ)
+	}
+	return kind, name, nil
+}
+
+func (g *Goroutine) runtimeTypeName(l *structLayout, raw []byte) (string, error) {
+	if l.has("_string") {
+		//before Go 1.7 the descriptor points to its name
+		p, err := l.uint(raw, "_string")
+		if err != nil || p == 0 {
+			return "", fmt.Errorf("no name")
+		}
+		return g.readString(uintptr(p))
+	}
+
+	//since Go 1.7 the name is at an offset into the type data of the module
+	off, err := l.anyUint(raw, "Str", "str")
+	if err != nil {
+		return "", err
+	}
+	tflag, err := l.anyUint(raw, "TFlag", "tflag")
+	if err != nil {
+		return "", err
+	}
+
+	reader := g.dbp.Dwarf.Reader()
+	mdaddr, err := addressFor(g.dbp, "runtime.firstmoduledata", reader)
+	if err != nil {
+		return "", err
+	}
+	md, err := g.dbp.structLayout("runtime.moduledata")
+	if err != nil {
+		return "", err
+	}
+	mdraw, err := md.read(g.dbp, mdaddr)
+	if err != nil {
+		return "", err
+	}
+	types, err := md.uint(mdraw, "types")
+	if err != nil {
+		return "", err
+	}
+
+	//the length follows a byte of flags, a varint since Go 1.17
+	varint := l.has("Str") || g.dbp.GoSymTable.LookupFunc("runtime.name.readvarint") != nil
+	name, err := g.dbp.readTypeName(types+off, varint)
+	if err != nil {
+		return "", err
+	}
+
+	if tflag&tflagExtraStar != 0 && strings.HasPrefix(name, "*") {
+		name = name[1:]
+	}
+	return name, nil
+}
+
+// Reads the name encoded at addr by the linker, after a byte of flags
+// comes its length, a big endian uint16 before Go 1.17, then its bytes.
+func (dbp *DebuggedProcess) readTypeName(addr uint64, varint bool) (string, error) {
+	hdr, err := dbp.readMemory(uintptr(addr), 1+binary.MaxVarintLen16)
+	if err != nil {
+		return "", err
+	}
+
+	var n, size int
+	if varint {
+		l, k := binary.Uvarint(hdr[1:])
+		if k <= 0 {
+			return "", fmt.Errorf("invalid name length at %#x", addr)
+		}
+		n, size = int(l), k
+	} else {
+		n, size = int(binary.BigEndian.Uint16(hdr[1:])), 2
+	}
+
+	data, err := dbp.readMemory(uintptr(addr)+1+uintptr(size), n)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// Fields error structs keep their message in: errors.errorString has s,
+// fmt.wrapError msg.
+var errorMessageFields = []string{"s", "msg"}
+
+// Reads the message of the error struct of type name at addr.
+func (g *Goroutine) readErrorMessage(name string, addr uint64) (string, bool) {
+	layout, err := g.dbp.structLayout(name)
+	if err != nil {
+		return "", false
+	}
+
+	for _, field := range errorMessageFields {
+		f, err := layout.field(field)
+		if err != nil || f.Type.Common().Name != "string" {
+			continue
+		}
+		s, err := g.readString(uintptr(addr + uint64(f.ByteOffset)))
+		if err != nil {
+			return "", false
+		}
+		return s, true
+	}
+
+	return "", false
+}
+
+// Messages of runtime.boundsError by its code, %x and %y are its x and
+// y fields, as the runtime formats them.
+var boundsErrorFmt = []string{
+	"index out of range [%x] with length %y",
+	"slice bounds out of range [:%x] with length %y",
+	"slice bounds out of range [:%x] with capacity %y",
+	"slice bounds out of range [%x:%y]",
+	"slice bounds out of range [::%x] with length %y",
+	"slice bounds out of range [::%x] with capacity %y",
+	"slice bounds out of range [:%x:%y]",
+	"slice bounds out of range [%x:%y:]",
+	"cannot convert slice with length %y to array or pointer to array with length %x",
+}
+
+// Messages of runtime.boundsError with a negative x, y does not matter.
+var boundsNegErrorFmt = []string{
+	"index out of range [%x]",
+	"slice bounds out of range [:%x]",
+	"slice bounds out of range [:%x]",
+	"slice bounds out of range [%x:]",
+	"slice bounds out of range [::%x]",
+	"slice bounds out of range [::%x]",
+	"slice bounds out of range [:%x:]",
+	"slice bounds out of range [%x::]",
+}
+
+// Formats the runtime.boundsError at addr like its Error method.
+func (g *Goroutine) readBoundsError(addr uint64) (string, error) {
+	layout, err := g.dbp.structLayout("runtime.boundsError")
+	if err != nil {
+		return "", err
+	}
+	data, err := layout.read(g.dbp, addr)
+	if err != nil {
+		return "", err
+	}
+
+	var v [4]uint64
+	for i, field := range []string{"x", "y", "signed", "code"} {
+		if v[i], err = layout.uint(data, field); err != nil {
+			return "", err
+		}
+	}
+	return formatBoundsError(v[0], v[1], v[2] != 0, v[3])
+}
+
+func formatBoundsError(x, y uint64, signed bool, code uint64) (string, error) {
+	if code >= uint64(len(boundsErrorFmt)) {
+		return "", fmt.Errorf("unknown bounds error code %d", code)
+	}
+
+	format, xs := boundsErrorFmt[code], strconv.FormatUint(x, 10)
+	if signed && int64(x) < 0 && code < uint64(len(boundsNegErrorFmt)) {
+		format, xs = boundsNegErrorFmt[code], strconv.FormatInt(int64(x), 10)
+	}
+	msg := strings.Replace(format, "%x", xs, 1)
+	msg = strings.Replace(msg, "%y", strconv.FormatUint(y, 10), 1)
+	return "runtime error: " + msg, nil
+}
@@ -0,0 +1,31 @@
+package proctl
+
+import "testing"
+
+func TestFormatBoundsError(t *testing.T) {
+	testcases := []struct {
+		x, y   uint64
+		signed bool
+		code   uint64
+		msg    string
+	}{
+		{5, 3, true, 0, "runtime error: index out of range [5] with length 3"},
+		{^uint64(0), 3, true, 0, "runtime error: index out of range [-1]"},
+		{^uint64(0), 3, false, 0, "runtime error: index out of range [18446744073709551615] with length 3"},
+		{4, 2, true, 3, "runtime error: slice bounds out of range [4:2]"},
+		{^uint64(1), 0, true, 3, "runtime error: slice bounds out of range [-2:]"},
+		{8, 4, true, 8, "runtime error: cannot convert slice with length 4 to array or pointer to array with length 8"},
+	}
+
+	for _, tc := range testcases {
+		msg, err := formatBoundsError(tc.x, tc.y, tc.signed, tc.code)
+		assertNoError(err, t, "formatBoundsError()")
+		if msg != tc.msg {
+			t.Fatalf("Expected %q got %q", tc.msg, msg)
+		}
+	}
+
+	if _, err := formatBoundsError(0, 0, false, 42); err == nil {
+		t.Fatal("expected an error for an unknown code")
+	}
+}
@@ -5,12 +5,13 @@ package proctl
 import (
 	"debug/dwarf"
 	"debug/gosym"
-	"encoding/binary"
+	"errors"
 	"fmt"
 	"log"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -23,7 +24,6 @@ import (
 // Struct representing a debugged process. Holds onto pid, register values,
 // process struct and process state.
 type DebuggedProcess struct {
-	debuggedProcess
 	Pid                 int
 	Process             *os.Process
 	Dwarf               *dwarf.Data
@@ -33,12 +33,45 @@ type DebuggedProcess struct {
 	Breakpoints         map[uint64]*Breakpoint
 	breakpointIDCounter int
 	running             bool
+	core                bool
+	backend             Backend
+	goroutines          map[int]*Goroutine
+	currentGoroutine    *Goroutine
+	selectedGoroutine   *Goroutine                      //goroutine print, locals and stack refer to, nil for the current one
+	hwThreads           map[int]bool                    //threads whose debug registers match HWBreakpoints
+	signals             map[syscall.Signal]SignalPolicy //policies changed with HandleSignal
+	stopOnPanic         bool                            //breakpoints on panicFunctions are set
+	checkpoints         []*Checkpoint                   //forked copies of the process, see Checkpoint
+	checkpointIDCounter int
+	stepFilters         []StepFilter //functions step goes through, see StepFilter
+	breakpointStops     int          //stops at user breakpoints so far, numbering them
 
 	//cache
 	allgaddr    uint64
 	allglenaddr uint64
+	layouts     *runtimeLayouts
 }
 
+const (
+	TE_BREAKPOINT = iota
+	TE_SIGNAL
+	TE_MANUAL
+	TE_EXCEPTION
+	TE_EXIT
+)
+
+type trapEvent struct {
+	gid  int
+	tid  int
+	typ  int
+	err  error
+	data []byte
+	sig  syscall.Signal //signal that stopped the thread of a TE_SIGNAL event
+}
+
+// Returned by run-control requests on a process loaded from a core file.
+var ErrCoreFile = errors.New("not supported on core files")
+
 type ManualStopError struct{}
 
 func (mse ManualStopError) Error() string {
@@ -52,11 +85,13 @@ func Launch(cmd []string) (*DebuggedProcess, error) {
 	proc.Stderr = os.Stderr
 	proc.SysProcAttr = &syscall.SysProcAttr{Ptrace: true}
 
-	if err := proc.Start(); err != nil {
+	var err error
+	execPtraceFunc(func() { err = proc.Start() })
+	if err != nil {
 		return nil, err
 	}
 
-	_, _, err := wait(proc.Process.Pid, 0)
+	_, _, err = wait(proc.Process.Pid, 0)
 	if err != nil {
 		return nil, fmt.Errorf("waiting for target execve failed: %s", err)
 	}
@@ -64,6 +99,198 @@ func Launch(cmd []string) (*DebuggedProcess, error) {
 	return newDebugProcess(proc.Process.Pid)
 }
 
+func wait(pid, options int) (int, *syscall.WaitStatus, error) {
+	var status syscall.WaitStatus
+	wpid, err := syscall.Wait4(pid, &status, options, nil)
+	return wpid, &status, err
+}
+
+func (dbp *DebuggedProcess) addGoroutine(gid int, tid int) *Goroutine {
+	log.Printf("addGroutine:%d %d", gid, tid)
+
+	dbp.goroutines[gid] = &Goroutine{
+		id:     gid,
+		dbp:    dbp,
+		tid:    tid,
+		chcont: nil,
+	}
+
+	return dbp.goroutines[gid]
+}
+
+// Returns the goroutine variables and stack traces refer to.
+func (dbp *DebuggedProcess) selected() *Goroutine {
+	if dbp.selectedGoroutine != nil {
+		return dbp.selectedGoroutine
+	}
+	return dbp.currentGoroutine
+}
+
+// Makes gid the goroutine print, locals, args and stack traces refer
+// to until the process resumes. Execution commands keep acting on the
+// goroutine that stopped.
+func (dbp *DebuggedProcess) SelectGoroutine(gid int) error {
+	if gid == dbp.currentGoroutine.id {
+		dbp.selectedGoroutine = nil
+		return nil
+	}
+
+	g, err := dbp.findGoroutine(gid)
+	if err != nil {
+		return err
+	}
+
+	dbp.selectedGoroutine = g
+	return nil
+}
+
+// Returns the id of the selected goroutine.
+func (dbp *DebuggedProcess) SelectedGoroutine() int {
+	return dbp.selected().id
+}
+
+// Obtains register values from what Delve considers to be the current
+// thread of the traced process.
+func (dbp *DebuggedProcess) Registers() (Registers, error) {
+	return dbp.registers(dbp.currentGoroutine.tid)
+}
+
+// Resume process.
+func (dbp *DebuggedProcess) Continue() error {
+	log.Println("Continue()")
+	if dbp.core {
+		return ErrCoreFile
+	}
+
+	err := dbp.currentGoroutine.next()
+	if err != nil {
+		//ignore ErrUnknownFDE
+		if _, ok := err.(frame.ErrUnknownFDE); !ok {
+			return err
+		}
+
+		log.Print(err)
+	}
+
+	return dbp.currentGoroutine.cont()
+}
+
+// Executes a single instruction.
+func (dbp *DebuggedProcess) StepInstruction() (err error) {
+	if dbp.core {
+		return ErrCoreFile
+	}
+
+	return dbp.currentGoroutine.step()
+}
+
+// Steps to the next source line, into function calls.
+func (dbp *DebuggedProcess) Step() error {
+	log.Print("Step()")
+	if dbp.core {
+		return ErrCoreFile
+	}
+
+	return dbp.currentGoroutine.stepInto()
+}
+
+// Step over function calls.
+func (dbp *DebuggedProcess) Next() error {
+	log.Print("Next()")
+	if dbp.core {
+		return ErrCoreFile
+	}
+
+	return dbp.currentGoroutine.next()
+}
+
+// Runs the current goroutine until the selected frame returns and
+// returns the results of the function.
+func (dbp *DebuggedProcess) StepOut() ([]*Variable, error) {
+	if dbp.core {
+		return nil, ErrCoreFile
+	}
+
+	if dbp.selectedGoroutine != nil {
+		return nil, fmt.Errorf("only goroutine %d can be stepped, goroutine %d is selected", dbp.currentGoroutine.id, dbp.selectedGoroutine.id)
+	}
+
+	return dbp.currentGoroutine.stepOut()
+}
+
+// Stops a running process, the stop is reported to Listen as
+// a TE_MANUAL event.
+func (dbp *DebuggedProcess) RequestManualStop() error {
+	log.Print("RequestManualStop(), curg:", dbp.currentGoroutine.id)
+
+	if !dbp.running {
+		fmt.Println("Not running")
+		return nil
+	}
+
+	return dbp.backend.Halt()
+}
+
+// Kills the process from within the Listen handler. Listen returns
+// once the process is gone, the handler must return without resuming
+// the process.
+func (dbp *DebuggedProcess) Kill() error {
+	if dbp.core {
+		return ErrCoreFile
+	}
+
+	if err := dbp.Process.Kill(); err != nil {
+		return err
+	}
+
+	//let Listen go on and pick up the exit
+	dbp.currentGoroutine.chwait <- struct{}{}
+	return nil
+}
+
+// Reports whether the process was loaded from a core file.
+func (dbp *DebuggedProcess) IsCore() bool {
+	return dbp.core
+}
+
+// Detaches from the process, leaving it running.
+func (dbp *DebuggedProcess) Detach() error {
+	return dbp.backend.Detach()
+}
+
+// Builds the TE_MANUAL event for a suspended process, preferring
+// a thread that is running a goroutine other than g0.
+func (dbp *DebuggedProcess) manualStopEvent() (*trapEvent, error) {
+	ths, err := dbp.getThreads()
+	if err != nil {
+		return nil, err
+	}
+	log.Print("ths:", ths)
+
+	//go though all threads, try to break at a not-g0 goroutine
+	for _, th := range ths {
+		gid, err := dbp.getGid(th)
+		if err != nil {
+			return nil, err
+		}
+
+		if gid > 0 {
+			return &trapEvent{
+				gid: gid,
+				tid: th,
+				typ: TE_MANUAL,
+			}, nil
+		}
+	}
+
+	//can't find any goroutine other than g0
+	return &trapEvent{
+		gid: 0,
+		tid: ths[0],
+		typ: TE_MANUAL,
+	}, nil
+}
+
 // Find a location by string (file+line, function, breakpoint id, addr)
 func (dbp *DebuggedProcess) FindLocation(str string) (uint64, error) {
 	// File + Line
@@ -127,12 +354,126 @@ func (dbp *DebuggedProcess) BreakByLocation(loc string) (*Breakpoint, error) {
 	if err != nil {
 		return nil, err
 	}
-	return dbp.Break(addr)
+
+	bp, err := dbp.Break(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	bp.Location = loc
+	return bp, nil
+}
+
+// Returns the user breakpoint that stopped the current goroutine,
+// nil if it stopped for any other reason.
+func (dbp *DebuggedProcess) CurrentBreakpoint() *Breakpoint {
+	if dbp.currentGoroutine == nil {
+		return nil
+	}
+	return dbp.currentGoroutine.breakpoint
+}
+
+// Returns the user breakpoint that stopped the current goroutine with
+// the number of the stop, every stop at a user breakpoint gets its own.
+// The breakpoint is nil if the goroutine stopped for any other reason.
+func (dbp *DebuggedProcess) CurrentBreakpointStop() (*Breakpoint, int) {
+	if dbp.currentGoroutine == nil {
+		return nil, 0
+	}
+	return dbp.currentGoroutine.breakpoint, dbp.currentGoroutine.breakpointStop
+}
+
+// Returns the user breakpoint with the given id.
+func (dbp *DebuggedProcess) FindBreakpoint(id int) (*Breakpoint, error) {
+	for _, bp := range dbp.Breakpoints {
+		if bp.ID == id && !bp.isTemp() {
+			return bp, nil
+		}
+	}
+
+	return nil, fmt.Errorf("No breakpoint %d", id)
+}
+
+// Sets the condition of breakpoint id, the process only stops there
+// when expr is true in the frame of the goroutine hitting it. An empty
+// expr removes the condition.
+func (dbp *DebuggedProcess) SetCondition(id int, expr string) (*Breakpoint, error) {
+	bp, err := dbp.FindBreakpoint(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if expr == "" {
+		bp.Cond, bp.cond = "", nil
+		return bp, nil
+	}
+
+	cond, err := parseCondition(expr)
+	if err != nil {
+		return nil, err
+	}
+
+	bp.Cond, bp.cond = expr, cond
+	return bp, nil
+}
+
+// Makes breakpoint id ignore its next count hits.
+func (dbp *DebuggedProcess) SetIgnoreCount(id, count int) (*Breakpoint, error) {
+	if count < 0 {
+		return nil, fmt.Errorf("invalid ignore count %d", count)
+	}
+
+	bp, err := dbp.FindBreakpoint(id)
+	if err != nil {
+		return nil, err
+	}
+
+	bp.IgnoreCount = count
+	return bp, nil
 }
 
 func (dbp *DebuggedProcess) PrintBreakpoints() {
 	for _, bp := range dbp.Breakpoints {
-		fmt.Printf("%d\t%#v\t%s:%d\t%s\n", bp.ID, bp.Addr, bp.File, bp.Line, bp.FunctionName)
+		if bp.isTemp() {
+			continue
+		}
+
+		fmt.Printf("%d\t%#v\t%s:%d\t%s", bp.ID, bp.Addr, bp.File, bp.Line, bp.FunctionName)
+		if bp.OneShot {
+			fmt.Print("\tonce")
+		}
+		if bp.Cond != "" {
+			fmt.Printf("\tif %s", bp.Cond)
+		}
+
+		fmt.Printf("\thits: %d", bp.TotalHitCount)
+		if len(bp.HitCount) > 0 {
+			gids := make([]int, 0, len(bp.HitCount))
+			for gid := range bp.HitCount {
+				gids = append(gids, gid)
+			}
+			sort.Ints(gids)
+
+			counts := make([]string, 0, len(gids))
+			for _, gid := range gids {
+				counts = append(counts, fmt.Sprintf("goroutine %d: %d", gid, bp.HitCount[gid]))
+			}
+			fmt.Printf(" (%s)", strings.Join(counts, ", "))
+		}
+
+		if bp.IgnoreCount > 0 {
+			fmt.Printf("\tignore next %d", bp.IgnoreCount)
+		}
+		fmt.Println()
+
+		for _, cmd := range bp.Commands {
+			fmt.Printf("\t> %s\n", cmd)
+		}
+	}
+	for _, bp := range dbp.HWBreakpoints {
+		if bp != nil {
+			fmt.Printf("%d\t%#v\t%s watchpoint\t%s\n", bp.ID, bp.Addr, bp.Watch, bp.Expr)
+		}
 	}
 }
 
@@ -143,11 +484,17 @@ func (dbp *DebuggedProcess) Clear(addr uint64) (*Breakpoint, error) {
 
 // Clears a breakpoint by location (function, file+line, address, breakpoint id)
 func (dbp *DebuggedProcess) ClearByLocation(loc string) (*Breakpoint, error) {
+	if id, err := strconv.Atoi(loc); err == nil {
+		if bp, err := dbp.ClearWatchpoint(id); err == nil {
+			return bp, nil
+		}
+	}
+
 	addr, err := dbp.FindLocation(loc)
 	if err != nil {
 		return nil, err
 	}
-	regs, err := registers(dbp.currentGoroutine.tid)
+	regs, err := dbp.registers(dbp.currentGoroutine.tid)
 	if err != nil {
 		return nil, err
 	}
@@ -168,17 +515,24 @@ func (dbp *DebuggedProcess) PrintThreadInfo() error {
 	log.Printf("threads:%#v", threads)
 
 	for _, th := range threads {
-		regs, err := registers(th)
+		regs, err := dbp.registers(th)
 		if err != nil {
 			return err
 		}
 		pc := regs.PC()
 
+		var g string
+		if gid, err := dbp.getGid(th); err != nil {
+			log.Printf("could not find the goroutine of thread %d: %s", th, err)
+		} else if gid > 0 {
+			g = fmt.Sprintf(" goroutine %d", gid)
+		}
+
 		f, l, fn := dbp.GoSymTable.PCToLine(pc)
 		if fn != nil {
-			fmt.Printf("Thread %d at %#v %s:%d %s\n", th, pc, f, l, fn.Name)
+			fmt.Printf("Thread %d at %#v %s:%d %s%s\n", th, pc, f, l, fn.Name, g)
 		} else {
-			fmt.Printf("Thread %d at %#v\n", th, pc)
+			fmt.Printf("Thread %d at %#v%s\n", th, pc, g)
 		}
 	}
 	return nil
@@ -193,7 +547,11 @@ func (iae InvalidAddressError) Error() string {
 }
 
 func (dbp *DebuggedProcess) CurrentPCForDisplay() (uint64, error) {
-	pc, err := dbp.currentGoroutine.pc()
+	if f := dbp.selected().frame; f != nil {
+		return f.lookupPC, nil
+	}
+
+	pc, err := dbp.selected().pc()
 	if err != nil {
 		return pc, err
 	} else if _, ok := dbp.Breakpoints[pc-1]; ok {
@@ -209,16 +567,7 @@ func (dbp *DebuggedProcess) CurrentPC() (uint64, error) {
 
 // Returns the value of the named symbol.
 func (dbp *DebuggedProcess) EvalSymbol(name string) (*Variable, error) {
-	if strings.HasPrefix(name, "0x") {
-		addr, err := strconv.ParseInt(name, 0, 64)
-		if err != nil {
-			return nil, err
-		}
-		d, err := dbp.readMemory(uintptr(addr), 8)
-		return &Variable{name, "0x" + strconv.FormatUint(binary.LittleEndian.Uint64(d), 16), "uint64"}, nil
-	} else {
-		return dbp.currentGoroutine.EvalSymbol(name)
-	}
+	return dbp.selected().EvalSymbol(name)
 }
 
 // Returns a reader for the dwarf data
@@ -287,24 +636,25 @@ func (dbp *DebuggedProcess) obtainGoSymbols(exe exefile, wg *sync.WaitGroup) {
 // * Dwarf .debug_line section
 // * Go symbol table.
 func (dbp *DebuggedProcess) LoadInformation() error {
-	var (
-		wg  sync.WaitGroup
-		exe exefile
-		err error
-	)
-
-	exe, err = dbp.findExecutable()
+	exe, err := dbp.findExecutable()
 	if err != nil {
 		return err
 	}
 
+	dbp.parseExecutable(exe)
+
+	return nil
+}
+
+// Parses the .debug_frame section and the Go symbol table of exe.
+func (dbp *DebuggedProcess) parseExecutable(exe exefile) {
+	var wg sync.WaitGroup
+
 	wg.Add(2)
 	go dbp.parseDebugFrame(exe, &wg)
 	go dbp.obtainGoSymbols(exe, &wg)
 
 	wg.Wait()
-
-	return nil
 }
 
 func (dbp *DebuggedProcess) Listen(handler func()) {
@@ -314,7 +664,7 @@ func (dbp *DebuggedProcess) Listen(handler func()) {
 			close(g.chcont)
 		}
 	}()
-	for evt := range dbp.chTrap {
+	for evt := range dbp.backend.Events() {
 		log.Printf("receive chTrap: %v", evt)
 		log.Print("running false")
 		dbp.running = false
@@ -332,8 +682,19 @@ func (dbp *DebuggedProcess) Listen(handler func()) {
 			evt.gid = gid
 		}
 
+		//the runtime runs injected calls on a goroutine of its own
+		for _, g := range dbp.goroutines {
+			if g.calling && g.tid == evt.tid {
+				evt.gid = g.id
+			}
+		}
+
 		switch evt.typ {
-		case TE_MANUAL, TE_BREAKPOINT:
+		case TE_MANUAL, TE_BREAKPOINT, TE_SIGNAL:
+			if evt.typ == TE_SIGNAL {
+				fmt.Printf("Thread %d received signal %s\n", evt.tid, SignalName(evt.sig))
+			}
+
 			if g, ok := dbp.goroutines[evt.gid]; ok {
 				g.tid = evt.tid
 				dbp.currentGoroutine = g
@@ -28,6 +28,7 @@ __attribute__ ((section ("__TEXT,__info_plist"),used)) =
 import "C"
 
 import (
+	"debug/gosym"
 	"debug/macho"
 	"errors"
 	"fmt"
@@ -37,8 +38,6 @@ import (
 	"runtime/debug"
 	"syscall"
 	"unsafe"
-
-	"github.com/chendesheng/delve/dwarf/frame"
 )
 
 const (
@@ -52,26 +51,10 @@ type exefile struct {
 	*macho.File
 }
 
-const (
-	TE_BREAKPOINT = iota
-	TE_SIGNAL
-	TE_MANUAL
-	TE_EXCEPTION
-	TE_EXIT
-)
-
-type trapEvent struct {
-	gid  int
-	tid  int
-	typ  int
-	err  error
-	data []byte
-}
-
-type debuggedProcess struct {
-	chTrap           chan *trapEvent //notify when mach exception happens
-	goroutines       map[int]*Goroutine
-	currentGoroutine *Goroutine
+// Backend implemented with mach task and thread calls.
+type machBackend struct {
+	dbp    *DebuggedProcess
+	chTrap chan *trapEvent //notify when mach exception happens
 }
 
 func (dbp *DebuggedProcess) findExecutable() (exefile, error) {
@@ -102,79 +85,37 @@ func (dbp *DebuggedProcess) findExecutable() (exefile, error) {
 	return exefile{machofile}, nil
 }
 
-func (dbp *DebuggedProcess) addGoroutine(gid int, tid int) *Goroutine {
-	log.Printf("addGroutine:%d %d", gid, tid)
-
-	dbp.goroutines[gid] = &Goroutine{
-		id:     gid,
-		dbp:    dbp,
-		tid:    tid,
-		chcont: nil,
-	}
-
-	return dbp.goroutines[gid]
+func (b *machBackend) Events() <-chan *trapEvent {
+	return b.chTrap
 }
 
-func (dbp *DebuggedProcess) RequestManualStop() error {
-	log.Print("RequestManualStop(), curg:", dbp.currentGoroutine.id)
-
-	if !dbp.running {
-		fmt.Println("Not running")
-		return nil
-	}
-
-	if err := dbp.suspend(); err != nil {
-		return err
-	}
-
-	ths, err := dbp.getThreads()
-	if err != nil {
+func (b *machBackend) Halt() error {
+	dbp := b.dbp
+	if err := b.Suspend(); err != nil {
 		return err
 	}
-	log.Print("ths:", ths)
 
-	allg, err := dbp.allG()
+	evt, err := dbp.manualStopEvent()
 	if err != nil {
 		return err
 	}
 
-	//go though all threads, try to break at a not-g0 goroutine
-	for _, th := range ths {
-		regs, err := registers(th)
-		log.Printf("regs:%#v", regs)
-		if err != nil {
-			return err
-		}
-
-		if gid := findGid(regs, allg); gid > 0 {
-			//We need correct currentGoroutine to print out current break location right after return
-			dbp.currentGoroutine = dbp.addGoroutine(gid, th)
-			dbp.chTrap <- &trapEvent{
-				gid: gid,
-				tid: th,
-				typ: TE_MANUAL,
-			}
-			return nil
-		}
-
-	}
-
-	//can't find any goroutine other than g0
-	dbp.chTrap <- &trapEvent{
-		gid: 0,
-		tid: ths[0],
-		typ: TE_MANUAL,
+	if evt.gid > 0 {
+		//We need correct currentGoroutine to print out current break location right after return
+		dbp.currentGoroutine = dbp.addGoroutine(evt.gid, evt.tid)
 	}
+	b.chTrap <- evt
 	return nil
 }
 
-func waitroutine(dbp *DebuggedProcess) {
+func (b *machBackend) waitroutine() {
+	dbp := b.dbp
 	for {
 		_, status, err := wait(dbp.Pid, 0)
 		if err != nil {
 			log.Print("wait err:", err)
 			if status.Exited() {
-				dbp.chTrap <- &trapEvent{
+				b.chTrap <- &trapEvent{
 					gid: 0,
 					tid: 0,
 					typ: TE_EXIT,
@@ -199,10 +140,9 @@ func waitroutine(dbp *DebuggedProcess) {
 	}
 }
 
-func wait(pid, options int) (int, *syscall.WaitStatus, error) {
-	var status syscall.WaitStatus
-	wpid, err := syscall.Wait4(pid, &status, options, nil)
-	return wpid, &status, err
+// Runs fn directly, mach calls may come from any thread.
+func execPtraceFunc(fn func()) {
+	fn()
 }
 
 var fnCatchExceptionRaise func(C.int, C.int, C.exception_type_t, C.exception_data_t, C.mach_msg_type_number_t) int
@@ -215,10 +155,10 @@ func catch_exception_raise(eport C.int, thread C.int, task C.int, exception C.ex
 	return C.int(fnCatchExceptionRaise(task, thread, exception, code, ncode))
 }
 
-func (dbp *DebuggedProcess) getThreads() ([]int, error) {
+func (b *machBackend) Threads() ([]int, error) {
 	pths := uintptr(0)
 	nth := 0
-	err := macherr(C.getthreads(C.int(dbp.Pid), unsafe.Pointer(&pths), (*C.int)(unsafe.Pointer(&nth))))
+	err := macherr(C.getthreads(C.int(b.dbp.Pid), unsafe.Pointer(&pths), (*C.int)(unsafe.Pointer(&nth))))
 	if err != nil {
 		return nil, err
 	}
@@ -239,16 +179,58 @@ func (dbp *DebuggedProcess) getThreads() ([]int, error) {
 	return res, nil
 }
 
+func (b *machBackend) Registers(tid int) (Registers, error) {
+	return registers(tid)
+}
+
+func (b *machBackend) SetRegisters(tid int, r Registers) error {
+	regs, ok := r.(*Regs)
+	if !ok {
+		return fmt.Errorf("can not set registers of type %T", r)
+	}
+	return macherr(C.setregs(C.int(tid), (*C.Regs)(unsafe.Pointer(regs))))
+}
+
+func (b *machBackend) FpRegisters(tid int) ([]byte, error) {
+	return nil, errors.New("floating point registers are not supported on darwin")
+}
+
+func (b *machBackend) SetFpRegisters(tid int, data []byte) error {
+	return errors.New("floating point registers are not supported on darwin")
+}
+
+func (b *machBackend) DebugRegister(tid, reg int) (uint64, error) {
+	var dr C.DebugRegs
+	if err := macherr(C.getdebugregs(C.int(tid), &dr)); err != nil {
+		return 0, err
+	}
+
+	//x86_debug_state64_t is __dr0 to __dr7
+	return (*[8]uint64)(unsafe.Pointer(&dr))[reg], nil
+}
+
+func (b *machBackend) SetDebugRegister(tid, reg int, value uint64) error {
+	var dr C.DebugRegs
+	if err := macherr(C.getdebugregs(C.int(tid), &dr)); err != nil {
+		return err
+	}
+
+	(*[8]uint64)(unsafe.Pointer(&dr))[reg] = value
+	return macherr(C.setdebugregs(C.int(tid), &dr))
+}
+
+func (b *machBackend) SetSignal(tid int, sig syscall.Signal) error {
+	return errors.New("delivering signals is not supported on darwin")
+}
+
 // Returns a new DebuggedProcess struct with sensible defaults.
 func newDebugProcess(pid int) (*DebuggedProcess, error) {
-	dbp := DebuggedProcess{
-		Pid:         pid,
-		Breakpoints: make(map[uint64]*Breakpoint),
-		debuggedProcess: debuggedProcess{
-			goroutines: make(map[int]*Goroutine),
-		},
+	dbp := newDebuggedProcess(pid)
+	b := &machBackend{
+		dbp:    dbp,
+		chTrap: make(chan *trapEvent, 100),
 	}
-	dbp.chTrap = make(chan *trapEvent, 100)
+	dbp.backend = b
 
 	pths := uintptr(0)
 	nth := 0
@@ -278,7 +260,7 @@ func newDebugProcess(pid int) (*DebuggedProcess, error) {
 		//log.Printf("task:%d, thread:0x%x, exception:%d, pc:0x%x", task, thread, exception, regs.PC())
 
 		//It looks like suspend will not take effect until this function return
-		err := dbp.suspend()
+		err := b.Suspend()
 		if err != nil {
 			log.Fatal(err)
 		}
@@ -296,7 +278,7 @@ func newDebugProcess(pid int) (*DebuggedProcess, error) {
 		}
 
 		//This won't block because chTrap is buffered
-		dbp.chTrap <- &trapEvent{
+		b.chTrap <- &trapEvent{
 			gid: -1, // -1 means the receiver should find goroutine from tid itself, find goroutine id may takes too long time.
 			tid: tid,
 			typ: evttype,
@@ -306,13 +288,13 @@ func newDebugProcess(pid int) (*DebuggedProcess, error) {
 	}
 
 	//stop at start
-	dbp.chTrap <- &trapEvent{
+	b.chTrap <- &trapEvent{
 		gid: 0,
 		tid: int(threads[0]),
 		typ: TE_MANUAL,
 	}
 
-	go waitroutine(&dbp)
+	go b.waitroutine()
 	go C.server()
 
 	proc, err := os.FindProcess(pid)
@@ -332,7 +314,7 @@ func newDebugProcess(pid int) (*DebuggedProcess, error) {
 		return nil, err
 	}
 
-	return &dbp, nil
+	return dbp, nil
 }
 
 func ptracekill(pid int) error {
@@ -355,37 +337,41 @@ func ptracecont(pid int) error {
 	return nil
 }
 
-// Obtains register values from what Delve considers to be the current
-// thread of the traced process.
-func (dbp *DebuggedProcess) Registers() (Registers, error) {
-	return registers(dbp.currentGoroutine.tid)
+// runtime.nanotime is based on mach_absolute_time on OS X, wait times
+// are not reported.
+func monotonicTime() int64 {
+	return 0
 }
 
-// Resume process.
-func (dbp *DebuggedProcess) Continue() error {
-	log.Println("Continue()")
-	err := dbp.currentGoroutine.next()
-	if err != nil {
-		//ignore ErrUnknownFDE
-		if _, ok := err.(frame.ErrUnknownFDE); !ok {
-			return err
-		}
+// Core files are ELF, they can only be opened on linux.
+func OpenCore(exe, core string) (*DebuggedProcess, error) {
+	return nil, errors.New("core files are not supported on darwin")
+}
 
-		log.Print(err)
-	}
+// Writing core files relies on /proc, it is only supported on linux.
+func (dbp *DebuggedProcess) Dump(path string) error {
+	return errors.New("dump is not supported on darwin")
+}
+
+// There is no /proc to look up mappings in, reads of unmapped memory
+// fail in vmread instead.
+func (dbp *DebuggedProcess) checkReadable(addr uint64, size int) error {
+	return nil
+}
 
-	return dbp.currentGoroutine.cont()
+// Checkpoints inject a fork with ptrace, they are only supported on linux.
+func (dbp *DebuggedProcess) Checkpoint(note string) (*Checkpoint, error) {
+	return nil, errors.New("checkpoints are not supported on darwin")
 }
 
-// Steps through process.
-func (dbp *DebuggedProcess) Step() (err error) {
-	return dbp.currentGoroutine.step()
+func (dbp *DebuggedProcess) RestartCheckpoint(id int) (*DebuggedProcess, error) {
+	return nil, errors.New("checkpoints are not supported on darwin")
 }
 
-// Step over function calls.
-func (dbp *DebuggedProcess) Next() error {
-	log.Print("Next()")
-	return dbp.currentGoroutine.next()
+// Injected calls change registers ptrace gives access to, they are
+// only supported on linux.
+func (g *Goroutine) callFunction(fn *gosym.Func, params, results []funcParam, values [][]byte) ([]*Variable, error) {
+	return nil, errors.New("calling functions is not supported on darwin")
 }
 
 func Attach(pid int) (*DebuggedProcess, error) {
@@ -406,22 +392,20 @@ func Attach(pid int) (*DebuggedProcess, error) {
 	return dbp, nil
 }
 
-func (dbp *DebuggedProcess) Detach() error {
-	if err := syscall.PtraceDetach(dbp.Pid); err != nil {
+func (b *machBackend) Detach() error {
+	if err := syscall.PtraceDetach(b.dbp.Pid); err != nil {
 		log.Print(err)
 	}
 
-	return macherr(C.int(C.detach(C.int(dbp.Pid))))
+	return macherr(C.int(C.detach(C.int(b.dbp.Pid))))
 }
 
-func (dbp *DebuggedProcess) resume() error {
-	log.Print("resume()")
-	return macherr(C.taskresume(C.int(dbp.Pid)))
+func (b *machBackend) Resume() error {
+	return macherr(C.taskresume(C.int(b.dbp.Pid)))
 }
 
-func (dbp *DebuggedProcess) suspend() error {
-	log.Print("suspend()")
-	return macherr(C.tasksuspend(C.int(dbp.Pid)))
+func (b *machBackend) Suspend() error {
+	return macherr(C.tasksuspend(C.int(b.dbp.Pid)))
 }
 
 //func threadSuspend(tid int) error {
@@ -432,21 +416,20 @@ func (dbp *DebuggedProcess) suspend() error {
 //	return macherr(C.int(C.thread_resume(C.thread_act_t(tid))))
 //}
 
-func (dbp *DebuggedProcess) writeMemory(addr uintptr, data []byte) (int, error) {
-	log.Printf("write memory:%#v, %#v", uint64(addr), data)
+func (b *machBackend) WriteMemory(addr uintptr, data []byte) (int, error) {
 	log.Print(string(debug.Stack()))
-	if err := macherr(C.vmwrite(C.int(dbp.Pid), C.ulong(addr), unsafe.Pointer(&data[0]), C.int(len(data)))); err != nil {
+	if err := macherr(C.vmwrite(C.int(b.dbp.Pid), C.ulong(addr), unsafe.Pointer(&data[0]), C.int(len(data)))); err != nil {
 		return 0, err
 	} else {
 		return len(data), nil
 	}
 }
 
-func (dbp *DebuggedProcess) readMemory(addr uintptr, size int) ([]byte, error) {
+func (b *machBackend) ReadMemory(addr uintptr, size int) ([]byte, error) {
 	data := make([]byte, size)
 	outsize := C.ulong(0)
 
-	if err := macherr(C.vmread(C.int(dbp.Pid), C.ulong(addr), C.int(len(data)), unsafe.Pointer(&data[0]), &outsize)); err != nil {
+	if err := macherr(C.vmread(C.int(b.dbp.Pid), C.ulong(addr), C.int(len(data)), unsafe.Pointer(&data[0]), &outsize)); err != nil {
 		return nil, err
 	} else {
 		return data, nil
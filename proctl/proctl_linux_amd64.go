@@ -0,0 +1,688 @@
+package proctl
+
+import (
+	"debug/elf"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	S_GOSYMTAB    = ".gosymtab"
+	S_GOPCLNTAB   = ".gopclntab"
+	S_TEXT        = ".text"
+	S_DEBUG_FRAME = ".debug_frame"
+)
+
+type exefile struct {
+	*elf.File
+}
+
+// State of a single traced thread.
+type tracedThread struct {
+	running bool
+	sig     syscall.Signal //signal to deliver on the next resume
+}
+
+// Backend implemented with ptrace(2).
+type ptraceBackend struct {
+	dbp    *DebuggedProcess
+	chTrap chan *trapEvent //notify when a thread stops on a trap
+
+	mu      sync.Mutex
+	threads map[int]*tracedThread
+	halt    bool //a manual stop was requested
+
+	forked  chan int //processes forked by a checkpoint, once they stopped
+	forking int      //thread a checkpoint forks from, its signals wait until the fork is done
+
+	pending []*trapEvent //stops found while suspending, reported before the process resumes
+}
+
+var (
+	ptraceChan     = make(chan func())
+	ptraceDoneChan = make(chan struct{})
+)
+
+func init() {
+	go ptraceRoutine()
+}
+
+// All ptrace requests must come from the thread that attached to the
+// tracee, so they are funneled through a single locked OS thread.
+func ptraceRoutine() {
+	runtime.LockOSThread()
+	for fn := range ptraceChan {
+		fn()
+		ptraceDoneChan <- struct{}{}
+	}
+}
+
+// Runs fn on the ptrace thread and waits for it to finish.
+func execPtraceFunc(fn func()) {
+	ptraceChan <- fn
+	<-ptraceDoneChan
+}
+
+func (dbp *DebuggedProcess) findExecutable() (exefile, error) {
+	return dbp.openExecutable(fmt.Sprintf("/proc/%d/exe", dbp.Pid))
+}
+
+// Opens the ELF executable at path and loads its dwarf data.
+func (dbp *DebuggedProcess) openExecutable(path string) (exefile, error) {
+	f, err := os.OpenFile(path, 0, os.ModePerm)
+	if err != nil {
+		return exefile{}, err
+	}
+
+	elffile, err := elf.NewFile(f)
+	if err != nil {
+		return exefile{}, err
+	}
+
+	data, err := elffile.DWARF()
+	if err != nil {
+		log.Print(err)
+		return exefile{}, err
+	}
+	dbp.Dwarf = data
+
+	return exefile{elffile}, nil
+}
+
+func (b *ptraceBackend) Events() <-chan *trapEvent {
+	return b.chTrap
+}
+
+func (b *ptraceBackend) Halt() error {
+	//waitroutine picks up the SIGSTOP and suspends the rest of the process
+	b.mu.Lock()
+	b.halt = true
+	b.mu.Unlock()
+
+	return syscall.Tgkill(b.dbp.Pid, b.dbp.Pid, syscall.SIGSTOP)
+}
+
+func (b *ptraceBackend) waitroutine() {
+	for {
+		tid, status, err := wait(-1, syscall.WALL)
+		if err != nil {
+			log.Print("wait err:", err)
+			if err == syscall.ECHILD {
+				b.chTrap <- &trapEvent{
+					gid: 0,
+					tid: 0,
+					typ: TE_EXIT,
+				}
+				return
+			}
+
+			continue
+		}
+
+		log.Printf("Wait4:%d, tid:%d, signal:%d, stop signal:%d", status, tid, status.Signal(), status.StopSignal())
+
+		if status.Exited() || status.Signaled() {
+			if tid == b.dbp.Pid {
+				b.chTrap <- &trapEvent{
+					gid: 0,
+					tid: 0,
+					typ: TE_EXIT,
+				}
+				return
+			}
+
+			b.removeThread(tid)
+			continue
+		}
+
+		if !status.Stopped() {
+			continue
+		}
+
+		switch sig := status.StopSignal(); {
+		case sig == syscall.SIGTRAP && status.TrapCause() == syscall.PTRACE_EVENT_CLONE:
+			if err := b.addClonedThread(tid); err != nil {
+				log.Print(err)
+			}
+
+			if err := ptracecont(tid, 0); err != nil {
+				log.Print(err)
+			}
+		case sig == syscall.SIGTRAP && status.TrapCause() == syscall.PTRACE_EVENT_FORK:
+			//the parent half of a checkpoint, the child reports its own
+			//stop. PTRACE_CONT would drop the single step over the syscall
+			if err := ptracesinglestep(tid); err != nil {
+				log.Print(err)
+			}
+		case sig == syscall.SIGTRAP:
+			b.setThreadStopped(tid, 0)
+			if err := b.Suspend(); err != nil {
+				log.Fatal(err)
+			}
+
+			b.chTrap <- &trapEvent{
+				gid: -1, // -1 means the receiver should find goroutine from tid itself
+				tid: tid,
+				typ: TE_BREAKPOINT,
+			}
+		case sig == syscall.SIGSTOP && b.isForkChild(tid):
+			//a checkpoint, it stays stopped until the session restarts from it
+			b.forked <- tid
+		case sig == syscall.SIGSTOP && b.takeHalt():
+			b.setThreadStopped(tid, 0)
+			if err := b.Suspend(); err != nil {
+				log.Fatal(err)
+			}
+
+			evt, err := b.dbp.manualStopEvent()
+			if err != nil {
+				log.Fatal(err)
+			}
+			b.chTrap <- evt
+		case sig == syscall.SIGSTOP:
+			//initial stop of a new thread or a leftover stop from Suspend()
+			if err := b.dbp.syncDebugRegisters(tid); err != nil {
+				log.Print(err)
+			}
+
+			if err := ptracecont(tid, 0); err != nil {
+				log.Print(err)
+			}
+		default:
+			stop, deliver := b.dbp.signalReceived(tid, sig, false)
+			if !stop {
+				if b.holdSignal(tid, deliver) {
+					//its handler would run instead of the injected fork
+					deliver = 0
+				}
+				if err := ptracecont(tid, int(deliver)); err != nil {
+					log.Print(err)
+				}
+				continue
+			}
+
+			b.setThreadStopped(tid, deliver)
+			if err := b.Suspend(); err != nil {
+				log.Fatal(err)
+			}
+
+			b.chTrap <- &trapEvent{
+				gid: -1,
+				tid: tid,
+				typ: TE_SIGNAL,
+				sig: sig,
+			}
+		}
+	}
+}
+
+func (b *ptraceBackend) takeHalt() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	halt := b.halt
+	b.halt = false
+	return halt
+}
+
+// Keeps sig pending on thread tid while a checkpoint forks from it,
+// reports whether it did.
+func (b *ptraceBackend) holdSignal(tid int, sig syscall.Signal) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	th, ok := b.threads[tid]
+	if !ok || sig == 0 || tid != b.forking {
+		return false
+	}
+	th.sig = sig
+	return true
+}
+
+func (b *ptraceBackend) setForking(tid int) {
+	b.mu.Lock()
+	b.forking = tid
+	b.mu.Unlock()
+}
+
+// Reports whether tid is a process forked by the traced one rather
+// than one of its threads.
+func (b *ptraceBackend) isForkChild(tid int) bool {
+	b.mu.Lock()
+	_, ok := b.threads[tid]
+	b.mu.Unlock()
+	if ok || tid == b.dbp.Pid {
+		return false
+	}
+
+	tgid, err := threadGroup(tid)
+	if err != nil {
+		log.Print(err)
+		return false
+	}
+	return tgid != b.dbp.Pid
+}
+
+// Returns the id of the process thread tid belongs to.
+func threadGroup(tid int) (int, error) {
+	data, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/status", tid))
+	if err != nil {
+		return 0, err
+	}
+
+	for _, line := range strings.Split(string(data), "\n") {
+		if strings.HasPrefix(line, "Tgid:") {
+			return strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, "Tgid:")))
+		}
+	}
+	return 0, fmt.Errorf("no thread group in /proc/%d/status", tid)
+}
+
+func (b *ptraceBackend) addClonedThread(tid int) error {
+	var (
+		msg uint
+		err error
+	)
+	execPtraceFunc(func() { msg, err = syscall.PtraceGetEventMsg(tid) })
+	if err != nil {
+		return fmt.Errorf("could not get event message: %s", err)
+	}
+
+	log.Printf("new thread:%d", msg)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.threads[int(msg)]; !ok {
+		b.threads[int(msg)] = &tracedThread{running: true}
+	}
+	return nil
+}
+
+func (b *ptraceBackend) removeThread(tid int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	delete(b.threads, tid)
+}
+
+func (b *ptraceBackend) setThreadStopped(tid int, sig syscall.Signal) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	th, ok := b.threads[tid]
+	if !ok {
+		th = &tracedThread{}
+		b.threads[tid] = th
+	}
+	th.running = false
+	if sig != 0 {
+		th.sig = sig
+	}
+}
+
+func (b *ptraceBackend) nextRunningThread() (int, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for tid, th := range b.threads {
+		if th.running {
+			return tid, true
+		}
+	}
+	return 0, false
+}
+
+func (b *ptraceBackend) Threads() ([]int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	res := make([]int, 0, len(b.threads))
+	for tid := range b.threads {
+		res = append(res, tid)
+	}
+	sort.Ints(res)
+
+	return res, nil
+}
+
+func (b *ptraceBackend) Registers(tid int) (Registers, error) {
+	return registers(tid)
+}
+
+func (b *ptraceBackend) SetRegisters(tid int, r Registers) error {
+	regs, ok := r.(*Regs)
+	if !ok {
+		return fmt.Errorf("can not set registers of type %T", r)
+	}
+	return setregs(tid, regs)
+}
+
+func (b *ptraceBackend) FpRegisters(tid int) ([]byte, error) {
+	return fpregisters(tid)
+}
+
+func (b *ptraceBackend) SetFpRegisters(tid int, data []byte) error {
+	return setFpregisters(tid, data)
+}
+
+// Offset of u_debugreg in struct user.
+const debugRegOffset = 848
+
+func (b *ptraceBackend) DebugRegister(tid, reg int) (uint64, error) {
+	var (
+		value uint64
+		errno syscall.Errno
+	)
+	execPtraceFunc(func() {
+		_, _, errno = syscall.Syscall6(syscall.SYS_PTRACE, syscall.PTRACE_PEEKUSR, uintptr(tid), uintptr(debugRegOffset+reg*8), uintptr(unsafe.Pointer(&value)), 0, 0)
+	})
+	if errno != 0 {
+		return 0, errno
+	}
+	return value, nil
+}
+
+func (b *ptraceBackend) SetDebugRegister(tid, reg int, value uint64) error {
+	var errno syscall.Errno
+	execPtraceFunc(func() {
+		_, _, errno = syscall.Syscall6(syscall.SYS_PTRACE, syscall.PTRACE_POKEUSR, uintptr(tid), uintptr(debugRegOffset+reg*8), uintptr(value), 0, 0)
+	})
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// Returns a new DebuggedProcess struct with sensible defaults.
+func newDebugProcess(pid int) (*DebuggedProcess, error) {
+	return traceProcess(pid, 0)
+}
+
+// Takes over the stopped process pid, it is reported stopped in
+// goroutine gid, -1 to look the goroutine up when the runtime already
+// runs, e.g. in a checkpoint.
+func traceProcess(pid, gid int) (*DebuggedProcess, error) {
+	dbp := newDebuggedProcess(pid)
+	b := &ptraceBackend{
+		dbp:     dbp,
+		chTrap:  make(chan *trapEvent, 100),
+		threads: make(map[int]*tracedThread),
+		forked:  make(chan int, 10),
+	}
+	dbp.backend = b
+
+	var err error
+	execPtraceFunc(func() { err = syscall.PtraceSetOptions(pid, syscall.PTRACE_O_TRACECLONE) })
+	if err != nil {
+		return nil, err
+	}
+	b.threads[pid] = &tracedThread{}
+
+	//the main thread is already stopped, attach the rest of them
+	tasks, err := ioutil.ReadDir(fmt.Sprintf("/proc/%d/task", pid))
+	if err != nil {
+		return nil, err
+	}
+	for _, task := range tasks {
+		tid, err := strconv.Atoi(task.Name())
+		if err != nil || tid == pid {
+			continue
+		}
+
+		execPtraceFunc(func() { err = syscall.PtraceAttach(tid) })
+		if err != nil {
+			return nil, fmt.Errorf("could not attach to thread %d: %s", tid, err)
+		}
+
+		if _, _, err := wait(tid, syscall.WALL); err != nil {
+			return nil, err
+		}
+
+		execPtraceFunc(func() { err = syscall.PtraceSetOptions(tid, syscall.PTRACE_O_TRACECLONE) })
+		if err != nil {
+			return nil, err
+		}
+		b.threads[tid] = &tracedThread{}
+	}
+
+	log.Printf("threads:%#v", b.threads)
+
+	proc, err := os.FindProcess(pid)
+	if err != nil {
+		return nil, err
+	}
+
+	dbp.Process = proc
+
+	err = dbp.LoadInformation()
+	if err != nil {
+		return nil, err
+	}
+
+	//stop at start
+	b.chTrap <- &trapEvent{
+		gid: gid,
+		tid: pid,
+		typ: TE_MANUAL,
+	}
+
+	go b.waitroutine()
+
+	return dbp, nil
+}
+
+func ptracecont(tid, sig int) error {
+	log.Print("ptracecont()")
+
+	var err error
+	execPtraceFunc(func() { err = syscall.PtraceCont(tid, sig) })
+	return err
+}
+
+func ptracesinglestep(tid int) error {
+	var err error
+	execPtraceFunc(func() { err = syscall.PtraceSingleStep(tid) })
+	return err
+}
+
+// Returns CLOCK_MONOTONIC in nanoseconds, the clock runtime.nanotime
+// reads on Linux.
+func monotonicTime() int64 {
+	var ts syscall.Timespec
+	if _, _, err := syscall.Syscall(syscall.SYS_CLOCK_GETTIME, 1, uintptr(unsafe.Pointer(&ts)), 0); err != 0 {
+		return 0
+	}
+	return ts.Nano()
+}
+
+func Attach(pid int) (*DebuggedProcess, error) {
+	var err error
+	execPtraceFunc(func() { err = syscall.PtraceAttach(pid) })
+	if err != nil {
+		return nil, err
+	}
+
+	_, _, err = wait(pid, syscall.WALL)
+	if err != nil {
+		return nil, fmt.Errorf("waiting for target to stop failed: %s", err)
+	}
+
+	dbp, err := newDebugProcess(pid)
+	if err != nil {
+		return nil, err
+	}
+
+	return dbp, nil
+}
+
+func (b *ptraceBackend) Detach() error {
+	ths, err := b.Threads()
+	if err != nil {
+		return err
+	}
+
+	for _, tid := range ths {
+		execPtraceFunc(func() { err = syscall.PtraceDetach(tid) })
+		if err != nil {
+			log.Print(err)
+		}
+	}
+
+	return nil
+}
+
+func (b *ptraceBackend) SetSignal(tid int, sig syscall.Signal) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	th, ok := b.threads[tid]
+	if !ok {
+		return fmt.Errorf("unknown thread %d", tid)
+	}
+	th.sig = sig
+	return nil
+}
+
+// Continues every stopped thread, delivering any signal that was
+// intercepted while suspending it. A stop found while suspending is
+// reported instead, the process stays stopped.
+func (b *ptraceBackend) Resume() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if len(b.pending) > 0 {
+		evt := b.pending[0]
+		b.pending = b.pending[1:]
+		b.chTrap <- evt
+		return nil
+	}
+
+	for tid, th := range b.threads {
+		if th.running {
+			continue
+		}
+
+		if err := ptracecont(tid, int(th.sig)); err != nil {
+			return err
+		}
+		th.running = true
+		th.sig = 0
+	}
+
+	return nil
+}
+
+// Stops every running thread. Must only be called from waitroutine
+// because it waits for the threads itself.
+func (b *ptraceBackend) Suspend() error {
+	for {
+		tid, ok := b.nextRunningThread()
+		if !ok {
+			return nil
+		}
+
+		if err := syscall.Tgkill(b.dbp.Pid, tid, syscall.SIGSTOP); err != nil {
+			if err == syscall.ESRCH {
+				b.removeThread(tid)
+				continue
+			}
+			return err
+		}
+
+		if err := b.waitForStop(tid); err != nil {
+			return err
+		}
+	}
+}
+
+// Waits until tid stops. The SIGSTOP sent by Suspend() may be preceded
+// by another event, in which case it is delivered after the next resume
+// and waitroutine discards it.
+func (b *ptraceBackend) waitForStop(tid int) error {
+	_, status, err := wait(tid, syscall.WALL)
+	if err != nil {
+		return err
+	}
+
+	if status.Exited() || status.Signaled() {
+		b.removeThread(tid)
+		return nil
+	}
+
+	switch sig := status.StopSignal(); {
+	case sig == syscall.SIGSTOP:
+		b.setThreadStopped(tid, 0)
+	case sig == syscall.SIGTRAP && status.TrapCause() == syscall.PTRACE_EVENT_CLONE:
+		b.setThreadStopped(tid, 0)
+		return b.addClonedThread(tid)
+	case sig == syscall.SIGTRAP:
+		b.setThreadStopped(tid, 0)
+
+		//rewind so the breakpoint is hit again after resume
+		regs, err := registers(tid)
+		if err != nil {
+			return err
+		}
+		if _, ok := b.dbp.Breakpoints[regs.PC()-1]; ok {
+			return regs.SetPC(tid, regs.PC()-1)
+		}
+
+		//a watchpoint traps after the access, it can not be hit again
+		dr6, err := b.DebugRegister(tid, 6)
+		if err != nil {
+			return err
+		}
+		if b.dbp.watchpointTrapped(dr6) {
+			b.mu.Lock()
+			b.pending = append(b.pending, &trapEvent{
+				gid: -1,
+				tid: tid,
+				typ: TE_BREAKPOINT,
+			})
+			b.mu.Unlock()
+		}
+	default:
+		_, deliver := b.dbp.signalReceived(tid, sig, true)
+		b.setThreadStopped(tid, deliver)
+	}
+
+	return nil
+}
+
+func (b *ptraceBackend) WriteMemory(addr uintptr, data []byte) (int, error) {
+	var (
+		n   int
+		err error
+	)
+	execPtraceFunc(func() { n, err = syscall.PtracePokeData(b.dbp.Pid, addr, data) })
+	if err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+func (b *ptraceBackend) ReadMemory(addr uintptr, size int) ([]byte, error) {
+	data := make([]byte, size)
+
+	var (
+		n   int
+		err error
+	)
+	execPtraceFunc(func() { n, err = syscall.PtracePeekData(b.dbp.Pid, addr, data) })
+	if err != nil {
+		return nil, err
+	}
+	if n != size {
+		return nil, fmt.Errorf("could not read memory at %#v: read %d of %d bytes", addr, n, size)
+	}
+	return data, nil
+}
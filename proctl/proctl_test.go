@@ -9,6 +9,7 @@ import (
 	"os/exec"
 	"path/filepath"
 	"runtime"
+	"strings"
 	"testing"
 	"time"
 )
@@ -62,7 +63,7 @@ func currentLineNumber(p *DebuggedProcess, t *testing.T) (string, int) {
 	return f, l
 }
 
-func TestStep(t *testing.T) {
+func TestStepInstruction(t *testing.T) {
 	withTestProcess("../_fixtures/testprog", t, func(p *DebuggedProcess) {
 		if p.currentGoroutine.id == 0 {
 			helloworldfunc := p.GoSymTable.LookupFunc("main.helloworld")
@@ -77,8 +78,8 @@ func TestStep(t *testing.T) {
 		regs := getRegisters(p, t)
 		rip := regs.PC()
 
-		err := p.Step()
-		assertNoError(err, t, "Step()")
+		err := p.StepInstruction()
+		assertNoError(err, t, "StepInstruction()")
 
 		regs = getRegisters(p, t)
 		if rip >= regs.PC() {
@@ -97,6 +98,74 @@ func TestStep(t *testing.T) {
 	})
 }
 
+func TestStep(t *testing.T) {
+	fp, err := filepath.Abs("../_fixtures/callprog.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	withTestProcess("../_fixtures/callprog", t, func(p *DebuggedProcess) {
+		if p.currentGoroutine.id == 0 {
+			pc, _, _ := p.GoSymTable.LineToPC(fp, 21)
+			_, err := p.Break(pc)
+			assertNoError(err, t, "Break()")
+			assertNoError(p.Continue(), t, "Continue()")
+			return
+		}
+
+		//steps into add, past its prologue
+		assertNoError(p.Step(), t, "Step()")
+
+		pc, err := p.CurrentPC()
+		assertNoError(err, t, "CurrentPC()")
+		f, l, fn := p.GoSymTable.PCToLine(pc)
+		if fn == nil || fn.Name != "main.add" || l != 9 {
+			t.Fatalf("Expected to be at main.add line 9, got %s:%d", f, l)
+		}
+
+		p.Continue()
+	})
+}
+
+func TestStepFilters(t *testing.T) {
+	fp, err := filepath.Abs("../_fixtures/stepfilterprog.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assertStepsInto := func(p *DebuggedProcess, name string, line int) {
+		assertNoError(p.Step(), t, "Step()")
+
+		pc, err := p.CurrentPC()
+		assertNoError(err, t, "CurrentPC()")
+		f, l, fn := p.GoSymTable.PCToLine(pc)
+		if fn == nil || fn.Name != name || (line != 0 && l != line) {
+			t.Fatalf("Expected to step into %s, got %s:%d", name, f, l)
+		}
+	}
+
+	withTestProcess("../_fixtures/stepfilterprog", t, func(p *DebuggedProcess) {
+		//the call through the interface goes through the wrapper of
+		//the value method, the call of Println through runtime.convT64
+		if p.currentGoroutine.id == 0 {
+			for _, line := range []int{18, 23} {
+				pc, _, _ := p.GoSymTable.LineToPC(fp, line)
+				_, err := p.Break(pc)
+				assertNoError(err, t, "Break()")
+			}
+			assertNoError(p.Continue(), t, "Continue()")
+			return
+		}
+
+		assertStepsInto(p, "main.counter.Count", 10)
+
+		assertNoError(p.Continue(), t, "Continue()")
+		assertStepsInto(p, "fmt.Println", 0)
+
+		p.Continue()
+	})
+}
+
 func TestStepProcess(t *testing.T) {
 	files := []string{"../_fixtures/testprog", "../_fixtures/testprog"}
 	lines := []int{18, 10}
@@ -130,7 +199,7 @@ func TestStepProcess(t *testing.T) {
 				}
 
 				fmt.Printf("pc:0x%x\n", pc)
-				if err := p.Step(); err != nil {
+				if err := p.StepInstruction(); err != nil {
 					t.Fatal(err)
 				}
 			}
@@ -201,8 +270,8 @@ func TestBreakpoint(t *testing.T) {
 			t.Fatalf("Break not respected:\nPC:%#v %s:%d\nFN:%#v \n", pc, f, l, breakpc)
 		}
 
-		err = p.Step()
-		assertNoError(err, t, "Step()")
+		err = p.StepInstruction()
+		assertNoError(err, t, "StepInstruction()")
 
 		pc, err = p.CurrentPC()
 		if err != nil {
@@ -373,6 +442,537 @@ func TestNext(t *testing.T) {
 	})
 }
 
+func TestWatchpoint(t *testing.T) {
+	fp, err := filepath.Abs("../_fixtures/testnextprog.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	withTestProcess("../_fixtures/testnextprog", t, func(p *DebuggedProcess) {
+		pc, _, _ := p.GoSymTable.LineToPC(fp, 24)
+		if p.currentGoroutine.id == 0 {
+			_, err := p.Break(pc)
+			assertNoError(err, t, "Break()")
+			assertNoError(p.Continue(), t, "Continue()")
+			return
+		}
+
+		_, err := p.Clear(pc)
+		assertNoError(err, t, "Clear()")
+
+		bp, err := p.Watch("j", WatchReadWrite)
+		assertNoError(err, t, "Watch()")
+
+		if err := p.Continue(); err != ErrInterrupt {
+			t.Fatalf("Expected watchpoint to stop the process, got %v", err)
+		}
+
+		if f, ln := currentLineNumber(p, t); ln != 24 {
+			t.Fatalf("Watchpoint stopped at the wrong location %s:%d", f, ln)
+		}
+
+		_, err = p.ClearWatchpoint(bp.ID)
+		assertNoError(err, t, "ClearWatchpoint()")
+
+		// j never changes, the watchpoint goes away with testnext's frame.
+		_, err = p.Watch("j", WatchWrite)
+		assertNoError(err, t, "Watch()")
+		assertNoError(p.Continue(), t, "Continue()")
+
+		if f, ln := currentLineNumber(p, t); ln != 41 {
+			t.Fatalf("Expected to stop when testnext returned, stopped at %s:%d", f, ln)
+		}
+
+		for _, bp := range p.HWBreakpoints {
+			if bp != nil {
+				t.Fatal("Watchpoint not removed when its frame returned")
+			}
+		}
+
+		go func() {
+			time.Sleep(100 * time.Millisecond)
+			if err := p.Process.Kill(); err != nil {
+				t.Error(err)
+			}
+		}()
+
+		p.Continue()
+	})
+}
+
+func TestConditionalBreakpoint(t *testing.T) {
+	fp, err := filepath.Abs("../_fixtures/testnextprog.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	withTestProcess("../_fixtures/testnextprog", t, func(p *DebuggedProcess) {
+		pc, _, _ := p.GoSymTable.LineToPC(fp, 24)
+		if p.currentGoroutine.id == 0 {
+			bp, err := p.Break(pc)
+			assertNoError(err, t, "Break()")
+
+			_, err = p.SetCondition(bp.ID, "i == 2")
+			assertNoError(err, t, "SetCondition()")
+			assertNoError(p.Continue(), t, "Continue()")
+			return
+		}
+
+		v, err := p.EvalSymbol("i")
+		assertNoError(err, t, "EvalSymbol()")
+		if v.Value != "2" {
+			t.Fatalf("Stopped with i = %s, expected 2", v.Value)
+		}
+
+		_, err = p.Clear(pc)
+		assertNoError(err, t, "Clear()")
+
+		go func() {
+			time.Sleep(100 * time.Millisecond)
+			if err := p.Process.Kill(); err != nil {
+				t.Error(err)
+			}
+		}()
+
+		p.Continue()
+	})
+}
+
+func TestStopOnPanic(t *testing.T) {
+	withTestProcess("../_fixtures/panicprog", t, func(p *DebuggedProcess) {
+		if p.currentGoroutine.id == 0 {
+			assertNoError(p.StopOnPanic(true), t, "StopOnPanic()")
+			p.Continue()
+			return
+		}
+
+		_, _, fn := p.GoSymTable.PCToLine(currentPC(p, t))
+		if fn == nil || fn.Name != "runtime.fatalpanic" && fn.Name != "runtime.dopanic" {
+			t.Fatalf("Stopped in %v, expected the panic to stop the program", fn)
+		}
+
+		frames, err := p.Stacktrace(10)
+		assertNoError(err, t, "Stacktrace()")
+
+		found := false
+		for _, f := range frames {
+			if f.Function == "main.explode" {
+				found = true
+			}
+		}
+		if !found {
+			t.Fatalf("main.explode not on the stack of the panicking goroutine: %v", frames)
+		}
+
+		p.Continue()
+	})
+}
+
+func TestPanicErrorValue(t *testing.T) {
+	withTestProcess("../_fixtures/panicerrprog", t, func(p *DebuggedProcess) {
+		if p.currentGoroutine.id == 0 {
+			assertNoError(p.StopOnPanic(true), t, "StopOnPanic()")
+			p.Continue()
+			return
+		}
+
+		v, err := p.currentGoroutine.panicValue()
+		assertNoError(err, t, "panicValue()")
+		if !strings.Contains(v, `"something went wrong"`) {
+			t.Fatalf("Panic value %s does not have the error message", v)
+		}
+		//the runtime turns errors into their message before it dies
+		//since Go 1.18, the type is string or *errors.errorString
+		if !strings.HasPrefix(v, "(string) ") && !strings.HasPrefix(v, "(*errors.errorString) ") {
+			t.Fatalf("Panic value %s does not have its type name", v)
+		}
+
+		p.Continue()
+	})
+}
+
+func TestHardcodedBreakpoint(t *testing.T) {
+	withTestProcess("../_fixtures/hardcodedbpprog", t, func(p *DebuggedProcess) {
+		if p.currentGoroutine.id == 0 {
+			p.Continue()
+			return
+		}
+
+		_, _, fn := p.GoSymTable.PCToLine(currentPC(p, t))
+		if fn == nil || fn.Name != "main.main" {
+			t.Fatalf("Stopped in %v, expected the caller of runtime.Breakpoint", fn)
+		}
+
+		if _, ok := p.Breakpoints[currentPC(p, t)-1]; ok {
+			t.Fatal("Hard-coded breakpoint added to the breakpoints of the debugger")
+		}
+
+		p.Continue()
+	})
+}
+
+func TestStacktrace(t *testing.T) {
+	withTestProcess("../_fixtures/testnextprog", t, func(p *DebuggedProcess) {
+		if p.currentGoroutine.id == 0 {
+			_, err := p.BreakByLocation("main.sleepytime")
+			assertNoError(err, t, "BreakByLocation()")
+			assertNoError(p.Continue(), t, "Continue()")
+			return
+		}
+
+		frames, err := p.Stacktrace(3)
+		assertNoError(err, t, "Stacktrace()")
+
+		expected := []string{"main.sleepytime", "main.testnext", "main.main"}
+		if len(frames) != len(expected) {
+			t.Fatalf("Expected %d frames got %d", len(expected), len(frames))
+		}
+		for i := range frames {
+			if frames[i].Function != expected[i] {
+				t.Fatalf("Expected %s in frame %d got %s", expected[i], i, frames[i].Function)
+			}
+		}
+		if frames[2].Line != 40 {
+			t.Fatalf("Expected main.main at line 40 got %d", frames[2].Line)
+		}
+
+		_, err = p.SelectFrame(1)
+		assertNoError(err, t, "SelectFrame()")
+
+		v, err := p.EvalSymbol("f")
+		assertNoError(err, t, "EvalSymbol()")
+		if v.Value != "2" {
+			t.Fatalf("Expected f = 2 in the caller frame got %s", v.Value)
+		}
+
+		go func() {
+			time.Sleep(100 * time.Millisecond)
+			if err := p.Process.Kill(); err != nil {
+				t.Error(err)
+			}
+		}()
+
+		p.ClearByLocation("main.sleepytime")
+		p.Continue()
+	})
+}
+
+func TestStepOut(t *testing.T) {
+	fp, err := filepath.Abs("../_fixtures/stepoutprog.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	withTestProcess("../_fixtures/stepoutprog", t, func(p *DebuggedProcess) {
+		pc, _, _ := p.GoSymTable.LineToPC(fp, 7)
+		if p.currentGoroutine.id == 0 {
+			_, err := p.Break(pc)
+			assertNoError(err, t, "Break()")
+			assertNoError(p.Continue(), t, "Continue()")
+			return
+		}
+
+		_, err := p.Clear(pc)
+		assertNoError(err, t, "Clear()")
+
+		//sum(0) returns to the same address as sum(1), only the
+		//frame of sum(1) returning must stop
+		_, err = p.SelectFrame(1)
+		assertNoError(err, t, "SelectFrame()")
+
+		results, err := p.StepOut()
+		assertNoError(err, t, "StepOut()")
+		if len(results) != 1 || results[0].Name != "~r0" || results[0].Value != "1" {
+			t.Fatalf("Unexpected results of sum(1): %#v", results)
+		}
+
+		frames, err := p.Stacktrace(2)
+		assertNoError(err, t, "Stacktrace()")
+		if frames[0].Function != "main.sum" || frames[1].Function != "main.sum" {
+			t.Fatalf("Expected to be back in sum(2), got %#v", frames)
+		}
+
+		_, err = p.BreakByLocation("main.divmod")
+		assertNoError(err, t, "BreakByLocation()")
+		assertNoError(p.Continue(), t, "Continue()")
+
+		results, err = p.StepOut()
+		assertNoError(err, t, "StepOut()")
+
+		values := make([]string, 0, len(results))
+		for _, v := range results {
+			values = append(values, v.Name+"="+v.Value)
+		}
+		if fmt.Sprint(values) != "[q=3 r=1]" {
+			t.Fatalf("Unexpected results of divmod(7, 2): %v", values)
+		}
+
+		p.Continue()
+	})
+}
+
+func TestCall(t *testing.T) {
+	fp, err := filepath.Abs("../_fixtures/callprog.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	withTestProcess("../_fixtures/callprog", t, func(p *DebuggedProcess) {
+		pc, _, _ := p.GoSymTable.LineToPC(fp, 22)
+		if p.currentGoroutine.id == 0 {
+			_, err := p.Break(pc)
+			assertNoError(err, t, "Break()")
+			assertNoError(p.Continue(), t, "Continue()")
+			return
+		}
+
+		results, err := p.Call("add(40, 2)")
+		assertNoError(err, t, "Call()")
+		if len(results) != 1 || results[0].Value != "42" || results[0].Type != "int" {
+			t.Fatalf("Unexpected results of add(40, 2): %#v", results)
+		}
+
+		results, err = p.Call("main.scale(1.5, 3)")
+		assertNoError(err, t, "Call()")
+		if len(results) != 1 || results[0].Value != "4.5" {
+			t.Fatalf("Unexpected results of scale(1.5, 3): %#v", results)
+		}
+
+		if _, err := p.Call("scale(1.5, 300)"); err == nil || !strings.Contains(err.Error(), "overflows") {
+			t.Fatalf("Expected an overflow error, got %v", err)
+		}
+		if _, err := p.Call("add(1)"); err == nil || !strings.Contains(err.Error(), "takes 2 arguments") {
+			t.Fatalf("Expected an argument count error, got %v", err)
+		}
+		if _, err := p.Call("fail()"); err == nil || !strings.Contains(err.Error(), "main.fail panicked") {
+			t.Fatalf("Expected the panic of fail(), got %v", err)
+		}
+
+		//the goroutine is back at the breakpoint after the calls
+		current, err := p.CurrentPC()
+		assertNoError(err, t, "CurrentPC()")
+		if current != pc {
+			t.Fatalf("Expected to be at %#v after the calls, got %#v", pc, current)
+		}
+
+		p.Continue()
+	})
+}
+
+func TestSelectParkedGoroutine(t *testing.T) {
+	withTestProcess("../_fixtures/goroutinestackprog", t, func(p *DebuggedProcess) {
+		if p.currentGoroutine.id == 0 {
+			_, err := p.BreakByLocation("main.stop")
+			assertNoError(err, t, "BreakByLocation()")
+			assertNoError(p.Continue(), t, "Continue()")
+			return
+		}
+
+		current := p.SelectedGoroutine()
+		parked := 0
+		for gid := 1; gid < 50; gid++ {
+			if gid == current || p.SelectGoroutine(gid) != nil {
+				continue
+			}
+
+			frames, err := p.Stacktrace(20)
+			assertNoError(err, t, "Stacktrace()")
+
+			for i, f := range frames {
+				if f.Function != "main.agoroutine" {
+					continue
+				}
+				parked++
+
+				_, err = p.SelectFrame(i)
+				assertNoError(err, t, "SelectFrame()")
+				_, err = p.EvalSymbol("n")
+				assertNoError(err, t, "EvalSymbol()")
+			}
+		}
+
+		if parked != 10 {
+			t.Fatalf("Expected 10 parked goroutines in main.agoroutine, found %d", parked)
+		}
+
+		assertNoError(p.SelectGoroutine(current), t, "SelectGoroutine()")
+		if _, err := p.StepOut(); err != nil {
+			t.Fatalf("Could not step out after switching back: %s", err)
+		}
+
+		p.Continue()
+	})
+}
+
+func TestGoroutineStates(t *testing.T) {
+	fp, err := filepath.Abs("../_fixtures/goroutinestackprog.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	withTestProcess("../_fixtures/goroutinestackprog", t, func(p *DebuggedProcess) {
+		if p.currentGoroutine.id == 0 {
+			_, err := p.BreakByLocation("main.stop")
+			assertNoError(err, t, "BreakByLocation()")
+			assertNoError(p.Continue(), t, "Continue()")
+			return
+		}
+
+		states, err := p.goroutineStates()
+		assertNoError(err, t, "goroutineStates()")
+
+		gostmt := fmt.Sprintf("%s:20 main.main", fp)
+		parked := 0
+		for _, s := range states {
+			locs := p.goroutineLocations(s)
+			if len(locs) != 4 || locs[2].location != gostmt {
+				continue
+			}
+			parked++
+
+			if s.statusString() != "waiting" {
+				t.Fatalf("Goroutine %d is %s, expected waiting", s.id, s.statusString())
+			}
+			if s.waitreason == "" {
+				t.Fatalf("Goroutine %d has no wait reason", s.id)
+			}
+			if !strings.HasSuffix(locs[1].location, "main.agoroutine") || !strings.HasSuffix(locs[3].location, "main.agoroutine") {
+				t.Fatalf("Unexpected locations of goroutine %d: %v", s.id, locs)
+			}
+		}
+
+		if parked != 10 {
+			t.Fatalf("Expected 10 goroutines created at %s, found %d", gostmt, parked)
+		}
+
+		p.Continue()
+	})
+}
+
+func TestRuntimeLayouts(t *testing.T) {
+	withTestProcess("../_fixtures/testprog", t, func(p *DebuggedProcess) {
+		layouts, err := p.runtimeLayouts()
+		assertNoError(err, t, "runtimeLayouts()")
+
+		if cached, _ := p.runtimeLayouts(); cached != layouts {
+			t.Fatal("Layouts are not cached")
+		}
+
+		for _, f := range []string{"goid", "stack", "sched", "m", "atomicstatus"} {
+			if !layouts.g.has(f) {
+				t.Fatalf("runtime.g has no field %s", f)
+			}
+		}
+
+		//stack is the first field of g, lo and hi its first words
+		data := make([]byte, layouts.g.size)
+		binary.LittleEndian.PutUint64(data[8:], 0x1000)
+		stack, err := layouts.g.bytes(data, "stack")
+		assertNoError(err, t, "bytes()")
+		if hi, err := layouts.stack.uint(stack, "hi"); err != nil || hi != 0x1000 {
+			t.Fatalf("Expected stack.hi 0x1000 got %#v %v", hi, err)
+		}
+
+		if _, err := layouts.m.field("nosuchfield"); err == nil {
+			t.Fatal("Expected an error for a missing field")
+		}
+
+		assertNoError(p.Kill(), t, "Kill()")
+	})
+}
+
+func TestThreadGoroutine(t *testing.T) {
+	withTestProcess("../_fixtures/testthreads", t, func(p *DebuggedProcess) {
+		if p.currentGoroutine.id == 0 {
+			_, err := p.BreakByLocation("main.anotherthread")
+			assertNoError(err, t, "BreakByLocation()")
+			assertNoError(p.Continue(), t, "Continue()")
+			return
+		}
+
+		gid, err := p.getGid(p.currentGoroutine.tid)
+		assertNoError(err, t, "getGid()")
+		bystack, err := p.getGidByStack(p.currentGoroutine.tid)
+		assertNoError(err, t, "getGidByStack()")
+
+		if gid != p.currentGoroutine.id || gid != bystack {
+			t.Fatalf("Thread %d runs goroutine %d, TLS says %d, stack bounds %d", p.currentGoroutine.tid, p.currentGoroutine.id, gid, bystack)
+		}
+
+		//the program ends once the goroutines stop hitting the breakpoint
+		p.ClearByLocation("main.anotherthread")
+		p.Continue()
+	})
+}
+
+func TestKillAndRelaunch(t *testing.T) {
+	for i := 0; i < 2; i++ {
+		withTestProcess("../_fixtures/testprog", t, func(p *DebuggedProcess) {
+			bp, err := p.BreakByLocation("main.helloworld")
+			assertNoError(err, t, "BreakByLocation()")
+
+			if bp.Location != "main.helloworld" {
+				t.Fatalf("Location not recorded: %q", bp.Location)
+			}
+
+			assertNoError(p.Kill(), t, "Kill()")
+		})
+	}
+}
+
+func TestCheckpoint(t *testing.T) {
+	var (
+		p  *DebuggedProcess
+		cp *Checkpoint
+	)
+	withTestProcess("../_fixtures/checkpointprog", t, func(dbp *DebuggedProcess) {
+		p = dbp
+		if p.currentGoroutine.id == 0 {
+			_, err := p.BreakByLocation("main.work")
+			assertNoError(err, t, "BreakByLocation()")
+			assertNoError(p.Continue(), t, "Continue()")
+			return
+		}
+
+		var err error
+		cp, err = p.Checkpoint("first call")
+		assertNoError(err, t, "Checkpoint()")
+		if cp.Function != "main.work" || cp.Note != "first call" {
+			t.Fatalf("Checkpoint recorded %s %q", cp.Function, cp.Note)
+		}
+
+		//the process goes on after the fork
+		assertNoError(p.Continue(), t, "Continue()")
+		assertNoError(p.Kill(), t, "Kill()")
+	})
+
+	p, err := p.RestartCheckpoint(cp.ID)
+	assertNoError(err, t, "RestartCheckpoint()")
+	if len(p.checkpoints) != 0 {
+		t.Fatalf("Checkpoint %d not used up", cp.ID)
+	}
+	_, err = p.BreakByLocation("main.work")
+	assertNoError(err, t, "BreakByLocation()")
+
+	hits := 0
+	p.Listen(func() {
+		if pc := currentPC(p, t); pc != cp.PC {
+			t.Fatalf("Restarted at %#v, expected %#v", pc, cp.PC)
+		}
+
+		//Continue does not return once the process exits
+		for {
+			assertNoError(p.Continue(), t, "Continue()")
+			hits++
+		}
+	})
+
+	//the first call was done before the checkpoint was taken
+	if hits != 2 {
+		t.Fatalf("Expected 2 more calls after the checkpoint got %d", hits)
+	}
+}
+
 func TestFindReturnAddress(t *testing.T) {
 	var testfile, _ = filepath.Abs("../_fixtures/testnextprog")
 
@@ -562,3 +1162,45 @@ func TestConcurrent2(t *testing.T) {
 		p.Continue()
 	})
 }
+
+func TestDisassemble(t *testing.T) {
+	fp, err := filepath.Abs("../_fixtures/callprog.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	withTestProcess("../_fixtures/callprog", t, func(p *DebuggedProcess) {
+		pc, _, _ := p.GoSymTable.LineToPC(fp, 22)
+		if p.currentGoroutine.id == 0 {
+			_, err := p.Break(pc)
+			assertNoError(err, t, "Break()")
+			assertNoError(p.Continue(), t, "Continue()")
+			return
+		}
+
+		fn := p.GoSymTable.LookupFunc("main.main")
+		insts, err := p.Disassemble(fn.Entry, fn.End)
+		assertNoError(err, t, "Disassemble()")
+
+		var atPC, callsAdd bool
+		for _, inst := range insts {
+			if inst.PC == pc {
+				atPC = inst.AtPC && inst.Breakpoint && inst.Line == 22
+				if inst.Bytes[0] == 0xcc {
+					t.Fatalf("Expected the original instruction under the breakpoint, got %#v", inst.Bytes)
+				}
+			}
+			if inst.Text == "CALL main.add(SB)" {
+				callsAdd = inst.Line == 21
+			}
+		}
+		if !atPC {
+			t.Fatalf("Expected the instruction at %#x to be marked as current and as breakpoint", pc)
+		}
+		if !callsAdd {
+			t.Fatal("Expected a call to main.add on line 21")
+		}
+
+		p.Continue()
+	})
+}
@@ -0,0 +1,195 @@
+package proctl
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// What the debugger does when the process receives a signal.
+type SignalPolicy struct {
+	Stop  bool //stop the process and report the signal
+	Print bool //print a line when the process receives the signal
+	Pass  bool //deliver the signal to the process
+}
+
+// Policy of the signals missing from defaultSignalPolicies.
+var defaultSignalPolicy = SignalPolicy{Stop: true, Print: true, Pass: true}
+
+// Signals a Go program receives in its normal course do not stop it.
+var defaultSignalPolicies = map[syscall.Signal]SignalPolicy{
+	syscall.SIGURG:    {Pass: true}, //preemption of goroutines
+	syscall.SIGPROF:   {Pass: true}, //runtime/pprof
+	syscall.SIGALRM:   {Pass: true},
+	syscall.SIGVTALRM: {Pass: true},
+	syscall.SIGCHLD:   {Pass: true},
+	syscall.SIGWINCH:  {Pass: true},
+	syscall.SIGIO:     {Pass: true},
+	syscall.SIGINT:    {}, //the terminal interrupt, the debugger stops the process itself
+}
+
+// Signals the debugger relies on, their policy can not be changed.
+var reservedSignals = map[syscall.Signal]string{
+	syscall.SIGTRAP: "is used by the debugger for breakpoints",
+	syscall.SIGSTOP: "is used by the debugger to stop threads",
+	syscall.SIGKILL: "can not be intercepted",
+}
+
+var signalNames = []struct {
+	name string
+	sig  syscall.Signal
+}{
+	{"SIGHUP", syscall.SIGHUP},
+	{"SIGINT", syscall.SIGINT},
+	{"SIGQUIT", syscall.SIGQUIT},
+	{"SIGILL", syscall.SIGILL},
+	{"SIGTRAP", syscall.SIGTRAP},
+	{"SIGABRT", syscall.SIGABRT},
+	{"SIGBUS", syscall.SIGBUS},
+	{"SIGFPE", syscall.SIGFPE},
+	{"SIGKILL", syscall.SIGKILL},
+	{"SIGUSR1", syscall.SIGUSR1},
+	{"SIGSEGV", syscall.SIGSEGV},
+	{"SIGUSR2", syscall.SIGUSR2},
+	{"SIGPIPE", syscall.SIGPIPE},
+	{"SIGALRM", syscall.SIGALRM},
+	{"SIGTERM", syscall.SIGTERM},
+	{"SIGCHLD", syscall.SIGCHLD},
+	{"SIGCONT", syscall.SIGCONT},
+	{"SIGSTOP", syscall.SIGSTOP},
+	{"SIGTSTP", syscall.SIGTSTP},
+	{"SIGTTIN", syscall.SIGTTIN},
+	{"SIGTTOU", syscall.SIGTTOU},
+	{"SIGURG", syscall.SIGURG},
+	{"SIGXCPU", syscall.SIGXCPU},
+	{"SIGXFSZ", syscall.SIGXFSZ},
+	{"SIGVTALRM", syscall.SIGVTALRM},
+	{"SIGPROF", syscall.SIGPROF},
+	{"SIGWINCH", syscall.SIGWINCH},
+	{"SIGIO", syscall.SIGIO},
+	{"SIGSYS", syscall.SIGSYS},
+}
+
+// Parses a signal given by name, with or without the SIG prefix, or
+// by number.
+func ParseSignal(s string) (syscall.Signal, error) {
+	if n, err := strconv.Atoi(s); err == nil {
+		if n <= 0 {
+			return 0, fmt.Errorf("invalid signal %s", s)
+		}
+		return syscall.Signal(n), nil
+	}
+
+	name := strings.ToUpper(s)
+	if !strings.HasPrefix(name, "SIG") {
+		name = "SIG" + name
+	}
+	for _, sn := range signalNames {
+		if sn.name == name {
+			return sn.sig, nil
+		}
+	}
+
+	return 0, fmt.Errorf("unknown signal %s", s)
+}
+
+// Returns the name of sig, e.g. SIGUSR1.
+func SignalName(sig syscall.Signal) string {
+	for _, sn := range signalNames {
+		if sn.sig == sig {
+			return sn.name
+		}
+	}
+	return fmt.Sprintf("SIG%d", int(sig))
+}
+
+// Returns what the debugger does when the process receives sig.
+func (dbp *DebuggedProcess) SignalPolicy(sig syscall.Signal) SignalPolicy {
+	if p, ok := dbp.signals[sig]; ok {
+		return p
+	}
+	if p, ok := defaultSignalPolicies[sig]; ok {
+		return p
+	}
+	return defaultSignalPolicy
+}
+
+// Changes what the debugger does when the process receives sig.
+func (dbp *DebuggedProcess) HandleSignal(sig syscall.Signal, p SignalPolicy) error {
+	if reason, ok := reservedSignals[sig]; ok {
+		return fmt.Errorf("%s %s", SignalName(sig), reason)
+	}
+
+	dbp.signals[sig] = p
+	return nil
+}
+
+// Delivers sig to the thread of the current goroutine when the process
+// resumes, replacing the signal that stopped it if any. Signal 0
+// resumes without a signal.
+func (dbp *DebuggedProcess) Signal(sig syscall.Signal) error {
+	if dbp.core {
+		return ErrCoreFile
+	}
+	if dbp.currentGoroutine == nil {
+		return fmt.Errorf("the process is not stopped")
+	}
+
+	return dbp.backend.SetSignal(dbp.currentGoroutine.tid, sig)
+}
+
+// Called by the backend when thread tid receives sig, stopping is set
+// when the process is already stopping for another event. Prints the
+// signal if the process is not going to stop for it and returns the
+// signal to deliver to the thread, 0 for none.
+func (dbp *DebuggedProcess) signalReceived(tid int, sig syscall.Signal, stopping bool) (stop bool, deliver syscall.Signal) {
+	p := dbp.SignalPolicy(sig)
+
+	if p.Print && (!p.Stop || stopping) {
+		fmt.Printf("Thread %d received signal %s, %s\n", tid, SignalName(sig), passString(p.Pass))
+	}
+	if p.Pass {
+		deliver = sig
+	}
+
+	return p.Stop, deliver
+}
+
+func passString(pass bool) string {
+	if pass {
+		return "passing it to the program"
+	}
+	return "not passing it to the program"
+}
+
+// Prints the policy of the given signals, of all known signals if none
+// is given.
+func (dbp *DebuggedProcess) PrintSignalInfo(sigs ...syscall.Signal) {
+	if len(sigs) == 0 {
+		for _, sn := range signalNames {
+			sigs = append(sigs, sn.sig)
+		}
+	}
+
+	yesno := func(b bool) string {
+		if b {
+			return "Yes"
+		}
+		return "No"
+	}
+
+	fmt.Printf("%-10s %-5s %-5s %s\n", "Signal", "Stop", "Print", "Pass")
+	for _, sig := range sigs {
+		p := dbp.SignalPolicy(sig)
+		fmt.Printf("%-10s %-5s %-5s %s\n", SignalName(sig), yesno(p.Stop), yesno(p.Print), yesno(p.Pass))
+	}
+}
+
+// Copies the signal policies changed on old, the process of which was
+// relaunched as dbp.
+func (dbp *DebuggedProcess) RestoreSignalPolicies(old *DebuggedProcess) {
+	for sig, p := range old.signals {
+		dbp.signals[sig] = p
+	}
+}
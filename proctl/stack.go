@@ -0,0 +1,144 @@
+package proctl
+
+import (
+	"debug/dwarf"
+	"encoding/binary"
+	"fmt"
+	"log"
+)
+
+// A function activation on the stack of a goroutine.
+type Stackframe struct {
+	PC        uint64 //return address for all but the innermost frame
+	CFA       uint64
+	Function  string
+	File      string
+	Line      int
+	Arguments []*Variable
+
+	lookupPC uint64 //PC, or the call instruction for outer frames
+}
+
+// Returns at most depth frames of g starting with the innermost one,
+// along with the arguments of each call.
+func (g *Goroutine) stacktrace(depth int) ([]Stackframe, error) {
+	frames, err := g.unwind(depth)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range frames {
+		args, err := g.inFrame(&frames[i]).variablesByTag(dwarf.TagFormalParameter)
+		if err != nil {
+			log.Printf("could not read arguments of %s: %s", frames[i].Function, err)
+			continue
+		}
+		frames[i].Arguments = args
+	}
+
+	return frames, nil
+}
+
+// Unwinds the stack of g using .debug_frame, returning at most depth
+// frames starting with the innermost one.
+func (g *Goroutine) unwind(depth int) ([]Stackframe, error) {
+	regs, err := g.registers()
+	if err != nil {
+		return nil, err
+	}
+
+	pc, sp := regs.PC(), regs.SP()
+	frames := make([]Stackframe, 0, depth)
+
+	for len(frames) < depth {
+		//return addresses point after the call, look up the call itself
+		lookup := pc
+		if len(frames) > 0 {
+			lookup--
+		}
+
+		f, l, fn := g.dbp.GoSymTable.PCToLine(lookup)
+		if fn == nil {
+			break
+		}
+
+		fde, err := g.dbp.FrameEntries.FDEForPC(lookup)
+		if err != nil {
+			if len(frames) == 0 {
+				return nil, err
+			}
+			break
+		}
+
+		fctx := fde.EstablishFrame(lookup)
+		frames = append(frames, Stackframe{
+			PC:       pc,
+			CFA:      uint64(int64(sp) + fctx.CFAOffset()),
+			Function: fn.Name,
+			File:     f,
+			Line:     l,
+			lookupPC: lookup,
+		})
+
+		//the call pushed the return address right below the CFA
+		cfa := frames[len(frames)-1].CFA
+		data, err := g.dbp.readMemory(uintptr(cfa)-ptrsize, int(ptrsize))
+		if err != nil {
+			break
+		}
+
+		pc, sp = binary.LittleEndian.Uint64(data), cfa
+		if pc == 0 {
+			break
+		}
+	}
+
+	return frames, nil
+}
+
+// Returns a copy of g evaluating variables in frame.
+func (g *Goroutine) inFrame(frame *Stackframe) *Goroutine {
+	fg := *g
+	fg.frame = frame
+	return &fg
+}
+
+// Returns the pc variables are looked up with, it is inside the call
+// instruction for frames other than the innermost one.
+func (g *Goroutine) framePC() (uint64, error) {
+	if g.frame != nil {
+		return g.frame.lookupPC, nil
+	}
+	return g.pc()
+}
+
+// Returns at most depth frames of the selected goroutine.
+func (dbp *DebuggedProcess) Stacktrace(depth int) ([]Stackframe, error) {
+	return dbp.selected().stacktrace(depth)
+}
+
+// Selects the frame print, locals and args are evaluated in, 0 is the
+// innermost frame. The selection is reset when the process resumes.
+func (dbp *DebuggedProcess) SelectFrame(n int) (*Stackframe, error) {
+	g := dbp.selected()
+
+	if n < 0 {
+		return nil, fmt.Errorf("invalid frame %d", n)
+	}
+
+	frames, err := g.stacktrace(n + 1)
+	if err != nil {
+		return nil, err
+	}
+	if n >= len(frames) {
+		return nil, fmt.Errorf("no frame %d, the stack has %d frames", n, len(frames))
+	}
+
+	g.frame, g.frameIndex = &frames[n], n
+	return g.frame, nil
+}
+
+// Returns the index of the selected frame.
+func (dbp *DebuggedProcess) SelectedFrame() int {
+	return dbp.selected().frameIndex
+}
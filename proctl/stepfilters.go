@@ -0,0 +1,120 @@
+package proctl
+
+import (
+	"debug/gosym"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// What a step filter matches.
+type StepFilterKind int
+
+const (
+	PackageFilter  StepFilterKind = iota //package path prefix
+	FileFilter                           //glob matching the file path or its base name
+	FunctionFilter                       //regexp matching the function name
+)
+
+var stepFilterKindNames = []string{"package", "file", "function"}
+
+func (k StepFilterKind) String() string {
+	return stepFilterKindNames[k]
+}
+
+// Excludes the functions it matches from step: calls into them are
+// stepped through to the next user function, or run to their return.
+type StepFilter struct {
+	Kind    StepFilterKind
+	Pattern string
+
+	re *regexp.Regexp
+}
+
+// The runtime and the wrappers the compiler generates, e.g. for calls
+// of value methods through a pointer, are not stepped into by default.
+var defaultStepFilters = []StepFilter{
+	{Kind: PackageFilter, Pattern: "runtime"},
+	{Kind: FileFilter, Pattern: "<autogenerated>"},
+}
+
+// Returns a filter of the given kind, checking its pattern.
+func NewStepFilter(kind StepFilterKind, pattern string) (StepFilter, error) {
+	f := StepFilter{Kind: kind, Pattern: pattern}
+
+	switch kind {
+	case PackageFilter:
+		if pattern == "" {
+			return f, fmt.Errorf("empty package path")
+		}
+	case FileFilter:
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return f, fmt.Errorf("invalid glob %s: %s", pattern, err)
+		}
+	case FunctionFilter:
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return f, err
+		}
+		f.re = re
+	default:
+		return f, fmt.Errorf("unknown step filter kind %d", kind)
+	}
+
+	return f, nil
+}
+
+// Reports whether the filter matches the function fn of package pkg,
+// declared in file.
+func (f *StepFilter) match(fn, pkg, file string) bool {
+	switch f.Kind {
+	case PackageFilter:
+		return pkg == f.Pattern || strings.HasPrefix(pkg, strings.TrimSuffix(f.Pattern, "/")+"/")
+	case FileFilter:
+		if ok, _ := filepath.Match(f.Pattern, file); ok {
+			return true
+		}
+		ok, _ := filepath.Match(f.Pattern, filepath.Base(file))
+		return ok
+	case FunctionFilter:
+		return f.re.MatchString(fn)
+	}
+	return false
+}
+
+// Returns the step filters in the order they were added.
+func (dbp *DebuggedProcess) StepFilters() []StepFilter {
+	return dbp.stepFilters
+}
+
+func (dbp *DebuggedProcess) AddStepFilter(f StepFilter) {
+	dbp.stepFilters = append(dbp.stepFilters, f)
+}
+
+// Removes the i-th step filter, counting from 1.
+func (dbp *DebuggedProcess) RemoveStepFilter(i int) error {
+	if i < 1 || i > len(dbp.stepFilters) {
+		return fmt.Errorf("No step filter %d", i)
+	}
+
+	dbp.stepFilters = append(dbp.stepFilters[:i-1:i-1], dbp.stepFilters[i:]...)
+	return nil
+}
+
+// Copies the step filters of old, the process of which was relaunched
+// as dbp.
+func (dbp *DebuggedProcess) RestoreStepFilters(old *DebuggedProcess) {
+	dbp.stepFilters = append([]StepFilter(nil), old.stepFilters...)
+}
+
+// Reports whether step skips fn.
+func (dbp *DebuggedProcess) stepFiltered(fn *gosym.Func) bool {
+	file, _, _ := dbp.GoSymTable.PCToLine(fn.Entry)
+	for i := range dbp.stepFilters {
+		if dbp.stepFilters[i].match(fn.Name, fn.PackageName(), file) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,39 @@
+package proctl
+
+import "testing"
+
+func TestStepFilterMatch(t *testing.T) {
+	testcases := []struct {
+		kind     StepFilterKind
+		pattern  string
+		fn, file string
+		pkg      string
+		matches  bool
+	}{
+		{PackageFilter, "runtime", "runtime.morestack", "/go/src/runtime/asm_amd64.s", "runtime", true},
+		{PackageFilter, "runtime", "runtime/debug.Stack", "/go/src/runtime/debug/stack.go", "runtime/debug", true},
+		{PackageFilter, "runtime", "runtimex.F", "/src/runtimex/f.go", "runtimex", false},
+		{PackageFilter, "github.com/x/", "github.com/x/y.F", "/src/github.com/x/y/f.go", "github.com/x/y", true},
+		{FileFilter, "<autogenerated>", "main.(*T).M", "<autogenerated>", "main", true},
+		{FileFilter, "*_gen.go", "main.decode", "/src/app/parse_gen.go", "main", true},
+		{FileFilter, "/src/app/*.go", "main.decode", "/src/app/parse.go", "main", true},
+		{FileFilter, "*_gen.go", "main.decode", "/src/app/parse.go", "main", false},
+		{FunctionFilter, `\.String$`, "main.(*T).String", "/src/app/t.go", "main", true},
+		{FunctionFilter, `^fmt\.`, "main.fmtValue", "/src/app/t.go", "main", false},
+	}
+
+	for _, tc := range testcases {
+		f, err := NewStepFilter(tc.kind, tc.pattern)
+		assertNoError(err, t, "NewStepFilter()")
+		if m := f.match(tc.fn, tc.pkg, tc.file); m != tc.matches {
+			t.Fatalf("%s filter %s on %s in %s: expected %v, got %v", tc.kind, tc.pattern, tc.fn, tc.file, tc.matches, m)
+		}
+	}
+
+	if _, err := NewStepFilter(FunctionFilter, "("); err == nil {
+		t.Fatal("Expected an error for an invalid regexp")
+	}
+	if _, err := NewStepFilter(FileFilter, "["); err == nil {
+		t.Fatal("Expected an error for an invalid glob")
+	}
+}
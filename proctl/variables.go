@@ -4,8 +4,12 @@ import (
 	"bytes"
 	"debug/dwarf"
 	"encoding/binary"
+	"errors"
 	"fmt"
+	"go/ast"
+	"go/parser"
 	"log"
+	"regexp"
 	"strconv"
 	"strings"
 	"unsafe"
@@ -25,6 +29,7 @@ type M struct {
 	spinning uint8
 	blocked  uint8
 	curg     uintptr
+	p        int //id of the P the M holds, -1 if none
 }
 
 const ptrsize uintptr = unsafe.Sizeof(int(1))
@@ -32,122 +37,83 @@ const ptrsize uintptr = unsafe.Sizeof(int(1))
 // Parses and returns select info on the internal M
 // data structures used by the Go scheduler.
 func (dbp *DebuggedProcess) AllM() ([]*M, error) {
+	layouts, err := dbp.runtimeLayouts()
+	if err != nil {
+		return nil, err
+	}
+
 	reader := dbp.Dwarf.Reader()
 
 	allmaddr, err := parseAllMPtr(dbp, reader)
 	if err != nil {
 		return nil, err
 	}
-	mptr, err := dbp.readMemory(uintptr(allmaddr), int(ptrsize))
+	m, err := dbp.readUint64(allmaddr)
 	if err != nil {
 		return nil, err
 	}
-	m := binary.LittleEndian.Uint64(mptr)
 	if m == 0 {
 		return nil, fmt.Errorf("allm contains no M pointers")
 	}
 
-	// parse addresses
-	procidInstructions, err := instructionsFor("procid", dbp, reader, true)
-	if err != nil {
-		return nil, err
-	}
-	spinningInstructions, err := instructionsFor("spinning", dbp, reader, true)
-	if err != nil {
-		return nil, err
-	}
-	alllinkInstructions, err := instructionsFor("alllink", dbp, reader, true)
-	if err != nil {
-		return nil, err
-	}
-	blockedInstructions, err := instructionsFor("blocked", dbp, reader, true)
-	if err != nil {
-		return nil, err
-	}
-	curgInstructions, err := instructionsFor("curg", dbp, reader, true)
-	if err != nil {
-		return nil, err
-	}
-
 	var allm []*M
-	for {
-		// curg
-		curgAddr, err := executeMemberStackProgram(mptr, curgInstructions)
+	for m != 0 {
+		data, err := layouts.m.read(dbp, m)
 		if err != nil {
 			return nil, err
 		}
-		curgBytes, err := dbp.readMemory(uintptr(curgAddr), int(ptrsize))
-		if err != nil {
-			return nil, fmt.Errorf("could not read curg %#v %s", curgAddr, err)
-		}
-		curg := binary.LittleEndian.Uint64(curgBytes)
 
-		// procid
-		procidAddr, err := executeMemberStackProgram(mptr, procidInstructions)
+		curg, err := layouts.m.uint(data, "curg")
 		if err != nil {
 			return nil, err
 		}
-		procidBytes, err := dbp.readMemory(uintptr(procidAddr), int(ptrsize))
+		procid, err := layouts.m.uint(data, "procid")
 		if err != nil {
-			return nil, fmt.Errorf("could not read procid %#v %s", procidAddr, err)
+			return nil, err
 		}
-		procid := binary.LittleEndian.Uint64(procidBytes)
-
-		// spinning
-		spinningAddr, err := executeMemberStackProgram(mptr, spinningInstructions)
+		spinning, err := layouts.m.uint(data, "spinning")
 		if err != nil {
 			return nil, err
 		}
-		spinBytes, err := dbp.readMemory(uintptr(spinningAddr), 1)
+		blocked, err := layouts.m.uint(data, "blocked")
 		if err != nil {
-			return nil, fmt.Errorf("could not read spinning %#v %s", spinningAddr, err)
+			return nil, err
 		}
 
-		// blocked
-		blockedAddr, err := executeMemberStackProgram(mptr, blockedInstructions)
+		pid := -1
+		paddr, err := layouts.m.uint(data, "p")
 		if err != nil {
 			return nil, err
 		}
-		blockBytes, err := dbp.readMemory(uintptr(blockedAddr), 1)
-		if err != nil {
-			return nil, fmt.Errorf("could not read blocked %#v %s", blockedAddr, err)
+		if paddr != 0 {
+			pdata, err := layouts.p.read(dbp, paddr)
+			if err != nil {
+				return nil, err
+			}
+			id, err := layouts.p.uint(pdata, "id")
+			if err != nil {
+				return nil, err
+			}
+			pid = int(int32(id))
 		}
 
 		allm = append(allm, &M{
 			procid:   procid,
-			blocked:  blockBytes[0],
-			spinning: spinBytes[0],
+			blocked:  uint8(blocked),
+			spinning: uint8(spinning),
 			curg:     uintptr(curg),
+			p:        pid,
 		})
 
 		// Follow the linked list
-		alllinkAddr, err := executeMemberStackProgram(mptr, alllinkInstructions)
-		if err != nil {
+		if m, err = layouts.m.uint(data, "alllink"); err != nil {
 			return nil, err
 		}
-		mptr, err = dbp.readMemory(uintptr(alllinkAddr), int(ptrsize))
-		if err != nil {
-			return nil, fmt.Errorf("could not read alllink %#v %s", alllinkAddr, err)
-		}
-		m = binary.LittleEndian.Uint64(mptr)
-
-		if m == 0 {
-			break
-		}
 	}
 
 	return allm, nil
 }
 
-func instructionsFor(name string, dbp *DebuggedProcess, reader *dwarf.Reader, member bool) ([]byte, error) {
-	reader.Seek(0)
-	entry, err := findDwarfEntry(name, reader, member)
-	if err != nil {
-		return nil, err
-	}
-	return instructionsForEntry(entry)
-}
-
 func instructionsForEntry(entry *dwarf.Entry) ([]byte, error) {
 	if entry.Tag == dwarf.TagMember {
 		instructions, ok := entry.Val(dwarf.AttrDataMemberLoc).([]byte)
@@ -202,9 +168,14 @@ type G struct {
 	stackhi uint64
 }
 
-//Find goroutine id by compare SP with G struct's stack field (stack.lo <= SP <= stack.hi)
-//FIXME: It's hacky, need better way to find thread's goroutine. I've already tried and failed: 1)read tls 2)use procid field (not work on OSX)
+// Reads the id and stack bounds of all goroutines, used to find the
+// goroutine of a thread where its TLS slot can not be read.
 func (dbp *DebuggedProcess) allG() ([]*G, error) {
+	layouts, err := dbp.runtimeLayouts()
+	if err != nil {
+		return nil, err
+	}
+
 	reader := dbp.Dwarf.Reader()
 
 	allglen, err := allglenval(dbp, reader)
@@ -213,23 +184,13 @@ func (dbp *DebuggedProcess) allG() ([]*G, error) {
 	}
 	log.Print("allglen:", allglen)
 
-	if dbp.allgaddr == 0 {
-		reader.Seek(0)
-		allgentryaddr, err := addressFor(dbp, "runtime.allg", reader)
-		if err != nil {
-			return nil, err
-		}
-
-		faddr, err := dbp.readMemory(uintptr(allgentryaddr), int(ptrsize))
-		if err != nil {
-			return nil, err
-		}
-
-		dbp.allgaddr = binary.LittleEndian.Uint64(faddr)
+	allgaddr, err := dbp.getAllgaddr(reader)
+	if err != nil {
+		return nil, err
 	}
-	log.Print("allgaddr:", dbp.allgaddr)
+	log.Print("allgaddr:", allgaddr)
 
-	allgptrbytes, err := dbp.readMemory(uintptr(dbp.allgaddr), int(allglen*uint64(ptrsize)))
+	allgptrbytes, err := dbp.readMemory(uintptr(allgaddr), int(allglen*uint64(ptrsize)))
 	if err != nil {
 		return nil, err
 	}
@@ -238,14 +199,27 @@ func (dbp *DebuggedProcess) allG() ([]*G, error) {
 	allg := make([]*G, allglen)
 	for i := uint64(0); i < allglen; i++ {
 		gaddr := binary.LittleEndian.Uint64(allgptrbytes[i*8 : i*8+8])
-		gbytes, err := dbp.readMemory(uintptr(gaddr), 136)
+		gbytes, err := layouts.g.read(dbp, gaddr)
 		if err != nil {
 			return nil, err
 		}
 
-		gid := binary.LittleEndian.Uint64(gbytes[128 : 128+8])
-		lo := binary.LittleEndian.Uint64(gbytes[:8])
-		hi := binary.LittleEndian.Uint64(gbytes[8 : 8+8])
+		gid, err := layouts.g.uint(gbytes, "goid")
+		if err != nil {
+			return nil, err
+		}
+		stack, err := layouts.g.bytes(gbytes, "stack")
+		if err != nil {
+			return nil, err
+		}
+		lo, err := layouts.stack.uint(stack, "lo")
+		if err != nil {
+			return nil, err
+		}
+		hi, err := layouts.stack.uint(stack, "hi")
+		if err != nil {
+			return nil, err
+		}
 		log.Printf("allg: gid: %#v, lo: %#v, hi: %#v\n", gid, lo, hi)
 
 		allg[i] = &G{int(gid), lo, hi}
@@ -254,8 +228,63 @@ func (dbp *DebuggedProcess) allG() ([]*G, error) {
 	return allg, nil
 }
 
+var errNoTLS = errors.New("thread local storage not supported")
+
+// Returns the id of the goroutine thread tid is running, 0 when it is
+// on its g0 without a user goroutine.
 func (dbp *DebuggedProcess) getGid(tid int) (int, error) {
-	regs, err := registers(tid)
+	gaddr, err := dbp.tlsG(tid)
+	if err == errNoTLS {
+		return dbp.getGidByStack(tid)
+	}
+	if err != nil {
+		return 0, err
+	}
+	if gaddr == 0 {
+		//the runtime has not set up the thread yet
+		return 0, nil
+	}
+
+	layouts, err := dbp.runtimeLayouts()
+	if err != nil {
+		return 0, err
+	}
+
+	data, err := layouts.g.read(dbp, gaddr)
+	if err != nil {
+		return 0, err
+	}
+	gid, err := layouts.g.uint(data, "goid")
+	if err != nil {
+		return 0, err
+	}
+	if gid != 0 {
+		return int(gid), nil
+	}
+
+	//g0 or gsignal, the goroutine of the thread is the current one of its M
+	m, err := layouts.g.uint(data, "m")
+	if err != nil || m == 0 {
+		return 0, err
+	}
+	mdata, err := layouts.m.read(dbp, m)
+	if err != nil {
+		return 0, err
+	}
+	curg, err := layouts.m.uint(mdata, "curg")
+	if err != nil || curg == 0 {
+		return 0, err
+	}
+
+	if data, err = layouts.g.read(dbp, curg); err != nil {
+		return 0, err
+	}
+	gid, err = layouts.g.uint(data, "goid")
+	return int(gid), err
+}
+
+func (dbp *DebuggedProcess) getGidByStack(tid int) (int, error) {
+	regs, err := dbp.registers(tid)
 	if err != nil {
 		return 0, err
 	}
@@ -268,6 +297,7 @@ func (dbp *DebuggedProcess) getGid(tid int) (int, error) {
 	return findGid(regs, allg), nil
 }
 
+//Find goroutine id by compare SP with G struct's stack field (stack.lo <= SP <= stack.hi)
 func findGid(regs Registers, allg []*G) int {
 	sp := regs.SP()
 
@@ -280,6 +310,41 @@ func findGid(regs Registers, allg []*G) int {
 	return 0
 }
 
+// Builds a Goroutine for gid evaluating variables with the registers
+// of the thread running it, or with the context saved in its sched
+// field when it is parked.
+func (dbp *DebuggedProcess) findGoroutine(gid int) (*Goroutine, error) {
+	ths, err := dbp.getThreads()
+	if err != nil {
+		return nil, err
+	}
+	for _, tid := range ths {
+		if id, err := dbp.getGid(tid); err == nil && id == gid {
+			return &Goroutine{dbp: dbp, id: gid, tid: tid}, nil
+		}
+	}
+
+	states, err := dbp.goroutineStates()
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range states {
+		if s.id == gid {
+			return dbp.goroutineOf(s), nil
+		}
+	}
+
+	return nil, fmt.Errorf("no goroutine %d", gid)
+}
+
+func (dbp *DebuggedProcess) readUint64(addr uint64) (uint64, error) {
+	data, err := dbp.readMemory(uintptr(addr), 8)
+	if err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint64(data), nil
+}
+
 func (dbp *DebuggedProcess) getAllgaddr(reader *dwarf.Reader) (uint64, error) {
 	if dbp.allgaddr == 0 {
 		reader.Seek(0)
@@ -299,80 +364,56 @@ func (dbp *DebuggedProcess) getAllgaddr(reader *dwarf.Reader) (uint64, error) {
 	return dbp.allgaddr, nil
 }
 
-func (dbp *DebuggedProcess) PrintGoroutinesInfo() error {
-	reader := dbp.Dwarf.Reader()
-
-	allglen, err := allglenval(dbp, reader)
+// Prints the goroutines whose status is status, or any when empty,
+// and with a location matching filter, when not nil.
+func (dbp *DebuggedProcess) PrintGoroutinesInfo(status string, filter *regexp.Regexp) error {
+	states, err := dbp.goroutineStates()
 	if err != nil {
 		return err
 	}
-	reader.Seek(0)
-	allgentryaddr, err := addressFor(dbp, "runtime.allg", reader)
-	if err != nil {
-		return err
-	}
-	fmt.Printf("[%d goroutines]\n", allglen)
-	faddr, err := dbp.readMemory(uintptr(allgentryaddr), int(ptrsize))
-	allg := binary.LittleEndian.Uint64(faddr)
 
-	for i := uint64(0); i < allglen; i++ {
-		err = printGoroutineInfo(dbp, allg+(i*uint64(ptrsize)), reader)
-		if err != nil {
-			return err
-		}
+	fmt.Printf("[%d goroutines]\n", len(states))
+	for _, s := range states {
+		printGoroutineInfo(dbp, s, status, filter)
 	}
 
 	return nil
 }
 
-func printGoroutineInfo(dbp *DebuggedProcess, addr uint64, reader *dwarf.Reader) error {
-	gaddrbytes, err := dbp.readMemory(uintptr(addr), int(ptrsize))
-	if err != nil {
-		return fmt.Errorf("error derefing *G %s", err)
-	}
-	initialInstructions := append([]byte{op.DW_OP_addr}, gaddrbytes...)
-
-	reader.Seek(0)
-	goidaddr, err := offsetFor("goid", reader, initialInstructions)
-	if err != nil {
-		return err
+func printGoroutineInfo(dbp *DebuggedProcess, s *goroutineState, status string, filter *regexp.Regexp) {
+	if status != "" && s.statusString() != status {
+		return
 	}
 
-	reader.Seek(0)
-	schedaddr, err := offsetFor("sched", reader, initialInstructions)
-	if err != nil {
-		return err
+	locs := dbp.goroutineLocations(s)
+	if filter != nil {
+		match := false
+		for _, loc := range locs {
+			if filter.MatchString(loc.location) {
+				match = true
+				break
+			}
+		}
+		if !match {
+			return
+		}
 	}
 
-	goidbytes, err := dbp.readMemory(uintptr(goidaddr), int(ptrsize))
-	if err != nil {
-		return fmt.Errorf("error reading goid %s", err)
+	header := fmt.Sprintf("Goroutine %d - %s", s.id, s.statusString())
+	if s.waitreason != "" {
+		header += " (" + s.waitreason + ")"
 	}
-
-	stacklobytes, err := dbp.readMemory(uintptr(addr), int(ptrsize))
-	if err != nil {
-		return fmt.Errorf("error reading stack %s", err)
+	if d := s.waitTime(dbp); d > 0 {
+		header += fmt.Sprintf(" for %s", d)
 	}
-	stacklo := binary.LittleEndian.Uint64(stacklobytes)
-
-	stackhibytes, err := dbp.readMemory(uintptr(addr+uint64(ptrsize)), int(ptrsize))
-	if err != nil {
-		return fmt.Errorf("error reading stack %s", err)
+	if s.tid != 0 {
+		header += fmt.Sprintf(", thread %d", s.tid)
 	}
-	stackhi := binary.LittleEndian.Uint64(stackhibytes)
+	fmt.Println(header)
 
-	schedbytes, err := dbp.readMemory(uintptr(schedaddr+uint64(ptrsize)), int(ptrsize))
-	if err != nil {
-		return fmt.Errorf("error reading sched %s", err)
+	for _, loc := range locs {
+		fmt.Printf("\t%s: %s\n", loc.kind, loc.location)
 	}
-	gopc := binary.LittleEndian.Uint64(schedbytes)
-	f, l, fn := dbp.GoSymTable.PCToLine(gopc)
-	fname := ""
-	if fn != nil {
-		fname = fn.Name
-	}
-	fmt.Printf("Goroutine %d - %s:%d %s\tstack:[%#v-%#v)\n", binary.LittleEndian.Uint64(goidbytes), f, l, fname, stacklo, stackhi)
-	return nil
 }
 
 func allglenval(dbp *DebuggedProcess, reader *dwarf.Reader) (uint64, error) {
@@ -417,48 +458,212 @@ func addressFor(dbp *DebuggedProcess, name string, reader *dwarf.Reader) (uint64
 	return uint64(addr), nil
 }
 
-func offsetFor(name string, reader *dwarf.Reader, parentinstr []byte) (uint64, error) {
-	entry, err := findDwarfEntry(name, reader, true)
+// Returns the value of the named symbol.
+func (g *Goroutine) EvalSymbol(name string) (*Variable, error) {
+	varName, memberName := splitMemberName(name)
+
+	entry, reader, err := g.findScopeVariable(varName)
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
-	instructions, ok := entry.Val(dwarf.AttrDataMemberLoc).([]byte)
-	if !ok {
-		return 0, fmt.Errorf("type assertion failed")
+
+	if len(memberName) == 0 {
+		return g.extractVariableFromEntry(entry)
 	}
-	offset, err := op.ExecuteStackProgram(0, append(parentinstr, instructions...))
+	return g.evaluateStructMember(entry, reader, memberName)
+}
+
+// Assigns the value of the right side of expr, "lhs = rhs", to the
+// variable, struct field or element on its left, in the selected frame
+// of the selected goroutine.
+func (dbp *DebuggedProcess) SetVariable(expr string) error {
+	if dbp.core {
+		return ErrCoreFile
+	}
+
+	i := assignmentIndex(expr)
+	if i < 0 {
+		return fmt.Errorf("%q is not an assignment", expr)
+	}
+	lhs, err := parser.ParseExpr(expr[:i])
 	if err != nil {
-		return 0, err
+		return fmt.Errorf("could not parse %q: %s", expr[:i], err)
+	}
+	rhs, err := parser.ParseExpr(expr[i+1:])
+	if err != nil {
+		return fmt.Errorf("could not parse %q: %s", expr[i+1:], err)
 	}
 
-	return uint64(offset), nil
+	g := dbp.selected()
+	addr, typ, inFrame, err := g.lvalue(lhs)
+	if err != nil {
+		return err
+	}
+	return g.assign(addr, typ, inFrame, rhs)
 }
 
-// Returns the value of the named symbol.
-func (g *Goroutine) EvalSymbol(name string) (*Variable, error) {
-	pc, err := g.pc()
+// Writes the value of rhs to addr. Nothing is written unless the value
+// has type typ, the address of a variable on the stack is only stored
+// on the stack (inFrame).
+func (g *Goroutine) assign(addr uint64, typ dwarf.Type, inFrame bool, rhs ast.Expr) error {
+	data, err := g.encodeExpr(rhs, typ, inFrame)
 	if err != nil {
-		return nil, err
+		return err
+	}
+	if int64(len(data)) != typ.Size() {
+		return fmt.Errorf("can not assign %d bytes to %s", len(data), typ)
 	}
 
-	reader := g.dbp.DwarfReader()
+	_, err = g.dbp.writeMemory(uintptr(addr), data)
+	return err
+}
 
-	_, err = reader.SeekToFunction(pc)
+// Returns the index of the = of an assignment, skipping comparison
+// operators and string literals, or -1.
+func assignmentIndex(expr string) int {
+	var quote byte
+	for i := 0; i < len(expr); i++ {
+		c := expr[i]
+		switch {
+		case quote != 0:
+			if c == '\\' && quote != '`' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'' || c == '`':
+			quote = c
+		case c == '=':
+			if i+1 < len(expr) && expr[i+1] == '=' {
+				i++
+				continue
+			}
+			if i > 0 && strings.IndexByte("!<>:", expr[i-1]) >= 0 {
+				continue
+			}
+			return i
+		}
+	}
+
+	return -1
+}
+
+// Returns the address and type of the named variable or struct member,
+// and whether it lives in the current stack frame.
+func (g *Goroutine) variableAddress(name string) (uint64, dwarf.Type, bool, error) {
+	varName, memberName := splitMemberName(name)
+
+	entry, reader, err := g.findScopeVariable(varName)
 	if err != nil {
-		return nil, err
+		return 0, nil, false, err
+	}
+
+	instructions, err := instructionsForEntry(entry)
+	if err != nil {
+		return 0, nil, false, err
+	}
+
+	addr, err := g.executeStackProgram(instructions)
+	if err != nil {
+		return 0, nil, false, err
+	}
+
+	//globals are located with DW_OP_addr, everything else relative to the frame
+	inFrame := len(instructions) > 0 && instructions[0] != op.DW_OP_addr
+
+	if len(memberName) == 0 {
+		offset, ok := entry.Val(dwarf.AttrType).(dwarf.Offset)
+		if !ok {
+			return 0, nil, false, fmt.Errorf("type assertion failed")
+		}
+
+		t, err := g.dbp.Dwarf.Type(offset)
+		if err != nil {
+			return 0, nil, false, err
+		}
+
+		return uint64(addr), t, inFrame, nil
+	}
+
+	parentAddr, err := g.extractVariableDataAddress(entry, reader)
+	if err != nil {
+		return 0, nil, false, err
+	}
+	if parentAddr == 0 {
+		return 0, nil, false, fmt.Errorf("%s is nil", varName)
+	}
+	if parentAddr != addr {
+		//dereferenced a pointer
+		inFrame = false
 	}
 
-	varName := name
-	memberName := ""
-	if strings.Contains(name, ".") {
-		idx := strings.Index(name, ".")
-		varName = name[:idx]
-		memberName = name[idx+1:]
+	if _, err = reader.SeekToType(entry, true, true); err != nil {
+		return 0, nil, false, err
+	}
+
+	for memberEntry, err := reader.NextMemberVariable(); memberEntry != nil; memberEntry, err = reader.NextMemberVariable() {
+		if err != nil {
+			return 0, nil, false, err
+		}
+
+		if n, ok := memberEntry.Val(dwarf.AttrName).(string); !ok || n != memberName {
+			continue
+		}
+
+		memberInstr, err := instructionsForEntry(memberEntry)
+		if err != nil {
+			return 0, nil, false, err
+		}
+
+		offset, ok := memberEntry.Val(dwarf.AttrType).(dwarf.Offset)
+		if !ok {
+			return 0, nil, false, fmt.Errorf("type assertion failed")
+		}
+
+		t, err := g.dbp.Dwarf.Type(offset)
+		if err != nil {
+			return 0, nil, false, err
+		}
+
+		baseAddr := make([]byte, 8)
+		binary.LittleEndian.PutUint64(baseAddr, uint64(parentAddr))
+
+		memberAddr, err := executeMemberStackProgram(baseAddr, memberInstr)
+		if err != nil {
+			return 0, nil, false, err
+		}
+
+		return memberAddr, t, inFrame, nil
+	}
+
+	return 0, nil, false, fmt.Errorf("%s has no member %s", varName, memberName)
+}
+
+func splitMemberName(name string) (string, string) {
+	if idx := strings.Index(name, "."); idx >= 0 {
+		return name[:idx], name[idx+1:]
+	}
+	return name, ""
+}
+
+// Finds the named variable in the scope of the current function, the
+// returned reader is positioned right after its entry.
+func (g *Goroutine) findScopeVariable(name string) (*dwarf.Entry, *reader.Reader, error) {
+	pc, err := g.framePC()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	reader := g.dbp.DwarfReader()
+
+	_, err = reader.SeekToFunction(pc)
+	if err != nil {
+		return nil, nil, err
 	}
 
 	for entry, err := reader.NextScopeVariable(); entry != nil; entry, err = reader.NextScopeVariable() {
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 
 		n, ok := entry.Val(dwarf.AttrName).(string)
@@ -466,15 +671,12 @@ func (g *Goroutine) EvalSymbol(name string) (*Variable, error) {
 			continue
 		}
 
-		if n == varName {
-			if len(memberName) == 0 {
-				return g.extractVariableFromEntry(entry)
-			}
-			return g.evaluateStructMember(entry, reader, memberName)
+		if n == name {
+			return entry, reader, nil
 		}
 	}
 
-	return nil, fmt.Errorf("could not find symbol value for %s", name)
+	return nil, nil, fmt.Errorf("could not find symbol value for %s", name)
 }
 
 func findDwarfEntry(name string, reader *dwarf.Reader, member bool) (*dwarf.Entry, error) {
@@ -621,7 +823,11 @@ func (g *Goroutine) extractVariableFromEntry(entry *dwarf.Entry) (*Variable, err
 
 // Execute the stack program taking into account the current stack frame
 func (g *Goroutine) executeStackProgram(instructions []byte) (int64, error) {
-	regs, err := registers(g.tid)
+	if g.frame != nil {
+		return op.ExecuteStackProgram(int64(g.frame.CFA), instructions)
+	}
+
+	regs, err := g.registers()
 	if err != nil {
 		return 0, err
 	}
@@ -737,6 +943,10 @@ func (g *Goroutine) extractValue(instructions []byte, addr int64, typ interface{
 		return g.readIntArray(ptraddress, t)
 	case *dwarf.IntType:
 		return g.readInt(ptraddress, t.ByteSize)
+	case *dwarf.UintType:
+		return g.readUint(ptraddress, t.ByteSize)
+	case *dwarf.BoolType:
+		return g.readBool(ptraddress)
 	case *dwarf.FloatType:
 		return g.readFloat(ptraddress, t.ByteSize)
 	}
@@ -835,6 +1045,37 @@ func (g *Goroutine) readInt(addr uintptr, size int64) (string, error) {
 	return strconv.Itoa(n), nil
 }
 
+func (g *Goroutine) readUint(addr uintptr, size int64) (string, error) {
+	var n uint64
+
+	val, err := g.dbp.readMemory(addr, int(size))
+	if err != nil {
+		return "", err
+	}
+
+	switch size {
+	case 1:
+		n = uint64(val[0])
+	case 2:
+		n = uint64(binary.LittleEndian.Uint16(val))
+	case 4:
+		n = uint64(binary.LittleEndian.Uint32(val))
+	case 8:
+		n = binary.LittleEndian.Uint64(val)
+	}
+
+	return strconv.FormatUint(n, 10), nil
+}
+
+func (g *Goroutine) readBool(addr uintptr) (string, error) {
+	val, err := g.dbp.readMemory(addr, 1)
+	if err != nil {
+		return "", err
+	}
+
+	return strconv.FormatBool(val[0] != 0), nil
+}
+
 func (g *Goroutine) readFloat(addr uintptr, size int64) (string, error) {
 	val, err := g.dbp.readMemory(addr, int(size))
 	if err != nil {
@@ -858,7 +1099,35 @@ func (g *Goroutine) readFloat(addr uintptr, size int64) (string, error) {
 
 // Fetches all variables of a specific type in the current function scope
 func (g *Goroutine) variablesByTag(tag dwarf.Tag) ([]*Variable, error) {
-	pc, err := g.pc()
+	return g.scopeVariables(func(entry *dwarf.Entry) bool {
+		return entry.Tag == tag
+	})
+}
+
+// Fetches the named and unnamed (~r0, ~r1...) results of the current
+// function.
+func (g *Goroutine) functionResults() ([]*Variable, error) {
+	return g.scopeVariables(func(entry *dwarf.Entry) bool {
+		if entry.Tag != dwarf.TagFormalParameter {
+			return false
+		}
+		return isResultEntry(entry)
+	})
+}
+
+// Reports whether the formal parameter entry is a result of its
+// function, older compilers only name them ~r0, ~r1...
+func isResultEntry(entry *dwarf.Entry) bool {
+	if isResult, ok := entry.Val(dwarf.AttrVarParam).(bool); ok {
+		return isResult
+	}
+	name, _ := entry.Val(dwarf.AttrName).(string)
+	return strings.HasPrefix(name, "~r")
+}
+
+// Fetches the variables of the current function scope matching filter
+func (g *Goroutine) scopeVariables(filter func(*dwarf.Entry) bool) ([]*Variable, error) {
+	pc, err := g.framePC()
 	if err != nil {
 		return nil, err
 	}
@@ -877,7 +1146,7 @@ func (g *Goroutine) variablesByTag(tag dwarf.Tag) ([]*Variable, error) {
 			return nil, err
 		}
 
-		if entry.Tag == tag {
+		if filter(entry) {
 			val, err := g.extractVariableFromEntry(entry)
 			if err != nil {
 				return nil, err
@@ -892,11 +1161,11 @@ func (g *Goroutine) variablesByTag(tag dwarf.Tag) ([]*Variable, error) {
 
 // LocalVariables returns all local variables from the current function scope
 func (dbp *DebuggedProcess) LocalVariables() ([]*Variable, error) {
-	return dbp.currentGoroutine.variablesByTag(dwarf.TagVariable)
+	return dbp.selected().variablesByTag(dwarf.TagVariable)
 }
 
 func (dbp *DebuggedProcess) PrintRegs() {
-	regs, err := registers(dbp.currentGoroutine.tid)
+	regs, err := dbp.registers(dbp.currentGoroutine.tid)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -905,7 +1174,7 @@ func (dbp *DebuggedProcess) PrintRegs() {
 
 // FunctionArguments returns the name, value, and type of all current function arguments
 func (dbp *DebuggedProcess) FunctionArguments() ([]*Variable, error) {
-	return dbp.currentGoroutine.variablesByTag(dwarf.TagFormalParameter)
+	return dbp.selected().variablesByTag(dwarf.TagFormalParameter)
 }
 
 // Sets the length of a slice.
@@ -0,0 +1,244 @@
+package proctl
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+)
+
+// Kind of memory access that triggers a watchpoint.
+type WatchType int
+
+const (
+	WatchRead WatchType = 1 << iota
+	WatchWrite
+	WatchReadWrite = WatchRead | WatchWrite
+)
+
+func (wt WatchType) String() string {
+	switch wt {
+	case WatchRead:
+		return "read"
+	case WatchWrite:
+		return "write"
+	case WatchReadWrite:
+		return "read/write"
+	}
+	return "unknown"
+}
+
+// Frame a watchpoint on a stack variable belongs to. The watchpoint
+// is removed when goroutine gid gets back to retaddr with the frame
+// popped off the stack.
+type watchScope struct {
+	gid     int
+	retaddr uint64
+	cfa     uint64
+}
+
+// Sets a watchpoint on the variable or struct member expr of the
+// current frame, using one of the four debug registers.
+func (dbp *DebuggedProcess) Watch(expr string, wt WatchType) (*Breakpoint, error) {
+	if dbp.core {
+		return nil, ErrCoreFile
+	}
+
+	reg := -1
+	for i, bp := range dbp.HWBreakpoints {
+		if bp == nil {
+			reg = i
+			break
+		}
+	}
+	if reg == -1 {
+		return nil, fmt.Errorf("all %d hardware breakpoints are in use", len(dbp.HWBreakpoints))
+	}
+
+	g := dbp.selected()
+	addr, typ, inFrame, err := g.variableAddress(expr)
+	if err != nil {
+		return nil, err
+	}
+
+	//debug registers watch 1, 2, 4 or 8 aligned bytes
+	size := 8
+	for size > 1 && (int64(size) > typ.Size() || addr%uint64(size) != 0) {
+		size /= 2
+	}
+	if int64(size) < typ.Size() {
+		return nil, fmt.Errorf("can not watch %s, %d bytes at %#x: a debug register watches at most %d aligned bytes there, watch one of its members instead", expr, typ.Size(), addr, size)
+	}
+
+	data, err := dbp.readMemory(uintptr(addr), size)
+	if err != nil {
+		return nil, err
+	}
+
+	value, err := g.extractValue(nil, int64(addr), typ)
+	if err != nil {
+		return nil, err
+	}
+
+	bp := dbp.newBreakpoint("", "", 0, addr, nil)
+	bp.Watch = wt
+	bp.Expr = expr
+	bp.watchSize = size
+	bp.watchType = typ
+	bp.watchData = data
+	bp.watchValue = value
+
+	if inFrame {
+		if bp.scope, err = g.frameScope(); err != nil {
+			return nil, err
+		}
+
+		if _, err := dbp.setBreakpoint(bp.scope.retaddr, bp.scope.gid); err != nil {
+			return nil, err
+		}
+	}
+
+	dbp.HWBreakpoints[reg] = bp
+	if err := dbp.updateHardwareBreakpoints(); err != nil {
+		dbp.clearWatchpoint(reg)
+		return nil, err
+	}
+
+	return bp, nil
+}
+
+// Returns the scope of the frame g is currently stopped in, or of the
+// selected frame.
+func (g *Goroutine) frameScope() (*watchScope, error) {
+	frames, err := g.unwind(g.frameIndex + 2)
+	if err != nil {
+		return nil, err
+	}
+	if len(frames) < g.frameIndex+2 {
+		return nil, fmt.Errorf("could not find the caller of frame %d", g.frameIndex)
+	}
+
+	return &watchScope{
+		gid:     g.id,
+		retaddr: frames[g.frameIndex+1].PC,
+		cfa:     frames[g.frameIndex].CFA,
+	}, nil
+}
+
+// Clears the watchpoint with the given breakpoint id.
+func (dbp *DebuggedProcess) ClearWatchpoint(id int) (*Breakpoint, error) {
+	for reg, bp := range dbp.HWBreakpoints {
+		if bp != nil && bp.ID == id {
+			return bp, dbp.clearWatchpoint(reg)
+		}
+	}
+
+	return nil, fmt.Errorf("No watchpoint %d", id)
+}
+
+func (dbp *DebuggedProcess) clearWatchpoint(reg int) error {
+	bp := dbp.HWBreakpoints[reg]
+	dbp.HWBreakpoints[reg] = nil
+
+	if bp.scope != nil {
+		if _, err := dbp.clearBreakpoint(bp.scope.retaddr, bp.scope.gid); err != nil {
+			log.Print(err)
+		}
+	}
+
+	return dbp.updateHardwareBreakpoints()
+}
+
+// Reads and resets DR6 of the thread running g. Prints the watchpoints
+// that triggered and reports whether g should stop because of them.
+func (dbp *DebuggedProcess) checkWatchpoints(g *Goroutine) (bool, error) {
+	if !dbp.hasHardwareBreakpoints() {
+		return false, nil
+	}
+
+	dr6, err := dbp.backend.DebugRegister(g.tid, 6)
+	if err != nil {
+		return false, err
+	}
+	if dr6&dr6Trapped == 0 {
+		return false, nil
+	}
+
+	if err := dbp.backend.SetDebugRegister(g.tid, 6, 0); err != nil {
+		return false, err
+	}
+
+	stop := false
+	for reg, bp := range dbp.HWBreakpoints {
+		if bp == nil || dr6&(1<<uint(reg)) == 0 {
+			continue
+		}
+
+		data, err := dbp.readMemory(uintptr(bp.Addr), bp.watchSize)
+		if err != nil {
+			return false, err
+		}
+
+		value, err := g.extractValue(nil, int64(bp.Addr), bp.watchType)
+		if err != nil {
+			return false, err
+		}
+
+		changed := !bytes.Equal(data, bp.watchData)
+		old := bp.watchValue
+		bp.watchData, bp.watchValue = data, value
+
+		switch {
+		case bp.Watch == WatchWrite && !changed, bp.Watch == WatchRead && changed:
+			continue
+		case changed:
+			fmt.Printf("Watchpoint %d: %s\nOld value: %s\nNew value: %s\n", bp.ID, bp.Expr, old, value)
+		default:
+			fmt.Printf("Watchpoint %d: %s\nValue: %s\n", bp.ID, bp.Expr, value)
+		}
+		stop = true
+	}
+
+	return stop, nil
+}
+
+// Called when g stops on breakpoint bp. Clears the watchpoints whose
+// frame returned and reports whether g stopped only because of the
+// scope of watchpoints whose frame is still live, e.g. on the return
+// of a recursive call.
+func (dbp *DebuggedProcess) checkWatchScopes(g *Goroutine, bp *Breakpoint) (bool, error) {
+	regs, err := dbp.registers(g.tid)
+	if err != nil {
+		return false, err
+	}
+
+	live, cleared := 0, 0
+	for reg, wp := range dbp.HWBreakpoints {
+		if wp == nil || wp.scope == nil || wp.scope.gid != g.id || wp.scope.retaddr != bp.Addr {
+			continue
+		}
+
+		if regs.SP() < wp.scope.cfa {
+			live++
+			continue
+		}
+
+		fmt.Printf("Watchpoint %d deleted because the program has left the block in which its expression is valid.\n", wp.ID)
+		if err := dbp.clearWatchpoint(reg); err != nil {
+			return false, err
+		}
+		cleared++
+	}
+
+	if live == 0 || cleared > 0 || bp.belongsTo(-1) {
+		return false, nil
+	}
+
+	owners := 0
+	for _, id := range bp.goroutines {
+		if id == g.id {
+			owners++
+		}
+	}
+
+	return owners == live, nil
+}